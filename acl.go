@@ -0,0 +1,21 @@
+package gocp
+
+import (
+	"errors"
+	"log/slog"
+)
+
+// errACLUnsupported is returned by copyACL where the platform has no access
+// control lists gocp knows how to copy.
+var errACLUnsupported = errors.New("access control lists are not supported")
+
+// preserveACL copies the access control list of src to dst. ACLs that can't
+// be read or applied are reported once to log as a warning instead of
+// failing the copy.
+func (r *report) preserveACL(log *slog.Logger, src, dst string) {
+	if err := copyACL(src, dst); err != nil {
+		r.aclWarning.Do(func() {
+			log.Warn("Cannot preserve access control lists", "path", src, "error", err)
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package gocp
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// aclXattrs are the extended attributes holding the POSIX ACLs of a file:
+// its access ACL and, for a folder, the default ACL its entries inherit.
+var aclXattrs = []string{"system.posix_acl_access", "system.posix_acl_default"}
+
+// copyACL sets the POSIX ACLs of src on dst.
+func copyACL(src, dst string) error {
+	for _, name := range aclXattrs {
+		value, err := readXattr(func(buf []byte) (int, error) { return unix.Getxattr(src, name, buf) })
+		if errors.Is(err, unix.ENODATA) || (err == nil && value == nil) {
+			// the mode bits say it all
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", name, aclError(err))
+		}
+		if err := unix.Setxattr(dst, name, value, 0); err != nil {
+			return fmt.Errorf("setting %s: %w", name, aclError(err))
+		}
+	}
+	return nil
+}
+
+// aclError marks the errors of file systems without ACLs.
+func aclError(err error) error {
+	if errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EOPNOTSUPP) {
+		return fmt.Errorf("%w: %w", errACLUnsupported, err)
+	}
+	return err
+}
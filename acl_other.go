@@ -0,0 +1,8 @@
+//go:build !(linux || windows)
+
+package gocp
+
+// copyACL only reports that ACLs are not supported on this platform.
+func copyACL(src, dst string) error {
+	return errACLUnsupported
+}
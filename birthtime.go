@@ -0,0 +1,14 @@
+package gocp
+
+import "os"
+
+// preserveBirthtime gives dst the creation time of the source file info
+// describes, on the platforms that keep one apart from the modification
+// time. It does nothing elsewhere.
+func preserveBirthtime(dst string, info os.FileInfo) error {
+	t, ok := birthtime(info)
+	if !ok {
+		return nil
+	}
+	return setBirthtime(dst, t)
+}
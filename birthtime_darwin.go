@@ -0,0 +1,33 @@
+package gocp
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// birthtime returns the creation time of the file info describes.
+func birthtime(info os.FileInfo) (time.Time, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(stat.Birthtimespec.Unix()), true
+}
+
+// setBirthtime sets the creation time of path with setattrlist, leaving
+// alone a file system that keeps none.
+func setBirthtime(path string, t time.Time) error {
+	attrs := unix.Attrlist{Bitmapcount: unix.ATTR_BIT_MAP_COUNT, Commonattr: unix.ATTR_CMN_CRTIME}
+	ts := unix.NsecToTimespec(t.UnixNano())
+	buf := unsafe.Slice((*byte)(unsafe.Pointer(&ts)), unsafe.Sizeof(ts))
+	err := unix.Setattrlist(path, &attrs, buf, unix.FSOPT_NOFOLLOW)
+	if errors.Is(err, unix.ENOTSUP) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,17 @@
+//go:build !darwin && !windows
+
+package gocp
+
+import (
+	"os"
+	"time"
+)
+
+// birthtime reports no creation time, which this platform can't set.
+func birthtime(os.FileInfo) (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func setBirthtime(string, time.Time) error {
+	return nil
+}
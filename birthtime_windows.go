@@ -0,0 +1,37 @@
+package gocp
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// birthtime returns the creation time of the file info describes.
+func birthtime(info os.FileInfo) (time.Time, bool) {
+	attrs, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(0, attrs.CreationTime.Nanoseconds()), true
+}
+
+// setBirthtime sets the creation time of path, a file or a folder, with
+// SetFileTime.
+func setBirthtime(path string, t time.Time) error {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return err
+	}
+	// backup semantics open folders as well
+	handle, err := syscall.CreateFile(name, syscall.FILE_WRITE_ATTRIBUTES,
+		syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE, nil, syscall.OPEN_EXISTING, syscall.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	defer syscall.CloseHandle(handle)
+	created := syscall.NsecToFiletime(t.UnixNano())
+	if err := syscall.SetFileTime(handle, &created, nil, nil); err != nil {
+		return &os.PathError{Op: "SetFileTime", Path: path, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,7 @@
+package gocp
+
+import "errors"
+
+// errCloneUnsupported is returned by cloneFile when the file can't be cloned
+// but may still be copied the regular way.
+var errCloneUnsupported = errors.New("cloning is not supported")
@@ -0,0 +1,30 @@
+package gocp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile creates dst as a copy-on-write clone of src with clonefile,
+// which APFS supports. The clone takes the mode of src rather than perm.
+func cloneFile(ctx context.Context, src, dst string, perm os.FileMode, opts *Options) error {
+	// clonefile refuses to replace an existing file
+	os.Remove(dst)
+	if err := unix.Clonefile(src, dst, unix.CLONE_NOFOLLOW); err != nil {
+		if errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EXDEV) {
+			return fmt.Errorf("%w: %w", errCloneUnsupported, err)
+		}
+		return err
+	}
+
+	info, err := os.Stat(dst)
+	if err != nil {
+		return err
+	}
+	opts.Progress.Add(info.Size())
+	return nil
+}
@@ -0,0 +1,98 @@
+package gocp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// copyRangeChunk is how much copy_file_range is asked to copy at a time, so
+// progress is reported and cancellation noticed during large files.
+const copyRangeChunk = 64 << 20
+
+// cloneFile creates dst as a reflink of src. In auto mode, when the file
+// system can't share the data, it copies it within the kernel using
+// copy_file_range if both files are on the same device.
+func cloneFile(ctx context.Context, src, dst string, perm os.FileMode, opts *Options) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+	srcInfo, err := srcFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	dstFile, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	defer dstFile.Close()
+
+	err = unix.IoctlFileClone(int(dstFile.Fd()), int(srcFile.Fd()))
+	if err == nil {
+		opts.Progress.Add(srcInfo.Size())
+		return dstFile.Close()
+	}
+	if opts.Reflink == ReflinkAlways {
+		return err
+	}
+	if opts.Sparse {
+		// copy_file_range may fill the holes in
+		return errCloneUnsupported
+	}
+
+	dstInfo, err := dstFile.Stat()
+	if err != nil {
+		return err
+	}
+	srcStat, ok1 := srcInfo.Sys().(*syscall.Stat_t)
+	dstStat, ok2 := dstInfo.Sys().(*syscall.Stat_t)
+	if !ok1 || !ok2 || srcStat.Dev != dstStat.Dev {
+		return errCloneUnsupported
+	}
+	if err := copyRange(ctx, srcFile, dstFile, srcInfo.Size(), opts.Progress); err != nil {
+		return err
+	}
+	return dstFile.Close()
+}
+
+// copyRange copies src to dst with copy_file_range. Failing before anything
+// was copied returns errCloneUnsupported, so the caller may fall back.
+func copyRange(ctx context.Context, src, dst *os.File, size int64, progress Progress) error {
+	var copied int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n, err := unix.CopyFileRange(int(src.Fd()), nil, int(dst.Fd()), nil, copyRangeChunk, 0)
+		if err != nil {
+			if copied == 0 && isCopyRangeUnsupported(err) {
+				return fmt.Errorf("%w: %w", errCloneUnsupported, err)
+			}
+			return err
+		}
+		if n == 0 {
+			if copied == 0 && size > 0 {
+				// some file systems report nothing to copy instead
+				return errCloneUnsupported
+			}
+			return nil
+		}
+		copied += int64(n)
+		progress.Add(int64(n))
+	}
+}
+
+// isCopyRangeUnsupported reports whether copy_file_range failed because it
+// can't be used for these files rather than because of an I/O error.
+func isCopyRangeUnsupported(err error) bool {
+	return errors.Is(err, unix.ENOSYS) || errors.Is(err, unix.EXDEV) ||
+		errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.EINVAL) ||
+		errors.Is(err, unix.EBADF) || errors.Is(err, unix.EPERM)
+}
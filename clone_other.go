@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package gocp
+
+import (
+	"context"
+	"os"
+)
+
+// cloneFile is not implemented on this platform.
+func cloneFile(ctx context.Context, src, dst string, perm os.FileMode, opts *Options) error {
+	return errCloneUnsupported
+}
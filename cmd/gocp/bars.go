@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/Joonk72/gocp"
+	"github.com/cheggaaa/pb/v3"
+	"github.com/dustin/go-humanize"
+)
+
+// maxBarFileName caps the length of the file names shown on worker bars.
+const maxBarFileName = 32
+
+// multiBar shows the total progress bar together with a bar per worker,
+// each following the file that worker is copying.
+type multiBar struct {
+	progressBar
+	workers int
+
+	pool *pb.Pool
+	bars []*pb.ProgressBar
+	idle chan *pb.ProgressBar
+}
+
+// workerBar is the gocp.FileTracker of a file shown on a worker bar.
+type workerBar struct {
+	bar  *pb.ProgressBar
+	idle chan *pb.ProgressBar
+}
+
+func (m *multiBar) Start(files, bytes uint64) {
+	m.progressBar.create(files, bytes)
+	m.idle = make(chan *pb.ProgressBar, m.workers)
+	for i := 0; i < m.workers; i++ {
+		bar := pb.New64(0)
+		bar.Set(pb.Bytes, true)
+		bar.SetTemplateString(`  {{string . "file"}} {{counters . }} {{bar . "[" "=" ">" "-" "]" }} {{percent . }}`)
+		m.bars = append(m.bars, bar)
+		m.idle <- bar
+	}
+
+	pool, err := pb.StartPool(append([]*pb.ProgressBar{m.bar}, m.bars...)...)
+	if err != nil {
+		// without a pool, show the total bar only
+		m.bar.Start()
+		return
+	}
+	m.pool = pool
+}
+
+func (m *multiBar) StartFile(path string, size int64) gocp.FileTracker {
+	select {
+	case bar := <-m.idle:
+		bar.Set("file", barFileName(path))
+		bar.SetTotal(size)
+		bar.SetCurrent(0)
+		return workerBar{bar, m.idle}
+	default:
+		// more files in flight than bars, e.g. without a pool
+		return workerBar{}
+	}
+}
+
+func (m *multiBar) Finish() {
+	for _, bar := range m.bars {
+		bar.Set("file", "")
+		bar.Finish()
+	}
+	m.flush()
+	m.bar.Finish()
+	if m.pool != nil {
+		m.pool.Stop()
+	}
+}
+
+func (w workerBar) Add(n int64) {
+	if w.bar != nil {
+		w.bar.Add64(n)
+	}
+}
+
+func (w workerBar) Done() {
+	if w.bar != nil {
+		w.bar.Set("file", "")
+		w.bar.SetTotal(0)
+		w.bar.SetCurrent(0)
+		w.idle <- w.bar
+	}
+}
+
+// barFileName returns the base name of path, shortened to fit on a bar.
+func barFileName(path string) string {
+	name := []rune(filepath.Base(path))
+	if len(name) > maxBarFileName {
+		name = append(name[:maxBarFileName-3], '.', '.', '.')
+	}
+	return string(name)
+}
+
+// textProgressInterval is how often textProgress prints a line.
+const textProgressInterval = 5 * time.Second
+
+// textProgress prints a plain progress line every few seconds, for output
+// that isn't a terminal and would be flooded by a progress bar.
+type textProgress struct {
+	files, bytes atomic.Uint64
+	doneFiles    atomic.Uint64
+	doneBytes    atomic.Uint64
+	stop         chan struct{}
+	stopped      chan struct{}
+}
+
+func (t *textProgress) Start(files, bytes uint64) {
+	t.SetTotal(files, bytes)
+	t.stop = make(chan struct{})
+	t.stopped = make(chan struct{})
+	go func() {
+		defer close(t.stopped)
+		ticker := time.NewTicker(textProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				t.print()
+			case <-t.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (t *textProgress) SetTotal(files, bytes uint64) {
+	t.files.Store(files)
+	t.bytes.Store(bytes)
+}
+
+func (t *textProgress) Increment() {
+	t.doneFiles.Add(1)
+}
+
+func (t *textProgress) Add(n int64) {
+	t.doneBytes.Add(uint64(n))
+}
+
+func (t *textProgress) Finish() {
+	close(t.stop)
+	<-t.stopped
+	t.print()
+}
+
+func (t *textProgress) print() {
+	fmt.Printf("Copied %d/%d file(s), %s/%s.\n", t.doneFiles.Load(), t.files.Load(),
+		humanize.IBytes(t.doneBytes.Load()), humanize.IBytes(t.bytes.Load()))
+}
+
+// rateHalfLife is how long it takes for the rate shown by ewmaRate to give
+// half its weight to what happened since.
+const rateHalfLife = 3 * time.Second
+
+// ewmaRate smooths the copy rate with an exponentially weighted moving
+// average, so it doesn't jump between small and large files, and estimates
+// the time left from the bytes left.
+type ewmaRate struct {
+	last    time.Time
+	written int64
+	rate    float64 // bytes per second
+}
+
+// update takes in the bytes written by now out of total and returns the rate
+// and estimated time left as a suffix for the bar.
+func (r *ewmaRate) update(written, total int64, now time.Time) string {
+	if r.last.IsZero() {
+		r.last, r.written = now, written
+		return ""
+	}
+	elapsed := now.Sub(r.last).Seconds()
+	if elapsed <= 0 {
+		return ""
+	}
+	current := float64(written-r.written) / elapsed
+	if r.rate == 0 {
+		r.rate = current
+	} else {
+		weight := 1 - math.Exp2(-elapsed/rateHalfLife.Seconds())
+		r.rate += weight * (current - r.rate)
+	}
+	r.last, r.written = now, written
+
+	if r.rate < 1 {
+		return ""
+	}
+	left := time.Duration(float64(max(total-written, 0)) / r.rate * float64(time.Second))
+	return fmt.Sprintf("%s/s, ETA %v", humanize.IBytes(uint64(r.rate)), left.Round(time.Second))
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Joonk72/gocp"
+)
+
+// sumEntry is a line of a -checksum-manifest.
+type sumEntry struct {
+	name string
+	sum  []byte
+}
+
+// checkSums re-hashes the files listed in the sha256sum manifest at path,
+// relative to the target, prints those that differ or are missing and
+// exits, with exitFailure if any does.
+func checkSums(ctx context.Context, opts gocp.Options, path string) {
+	entries, err := readSums(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot read checksum manifest: %v.\n", err)
+		os.Exit(exitUsage)
+	}
+
+	var mu sync.Mutex
+	var mismatched, missing, failed []string
+	pool := gocp.NewThreadPool(int(opts.Threads))
+	for _, entry := range entries {
+		entry := entry
+		err := pool.Submit(ctx, func() {
+			same, err := sameSum(filepath.Join(opts.Target, entry.name), entry.sum)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case os.IsNotExist(err):
+				missing = append(missing, entry.name)
+			case err != nil:
+				failed = append(failed, fmt.Sprintf("%s: %v", entry.name, err))
+			case !same:
+				mismatched = append(mismatched, entry.name)
+			}
+		}, nil)
+		if err != nil {
+			break
+		}
+	}
+	pool.Stop()
+	if err := ctx.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, "\nInterrupted.")
+		if err == context.Canceled {
+			os.Exit(exitInterrupted)
+		}
+		os.Exit(exitFailure)
+	}
+
+	sort.Strings(mismatched)
+	sort.Strings(missing)
+	sort.Strings(failed)
+	for _, name := range mismatched {
+		fmt.Println("mismatch: " + name)
+	}
+	for _, name := range missing {
+		fmt.Println("missing:  " + name)
+	}
+	for _, line := range failed {
+		fmt.Fprintln(os.Stderr, "Cannot read "+line)
+	}
+	infof("\n%d checked, %d mismatched, %d missing, %d unreadable.\n",
+		len(entries), len(mismatched), len(missing), len(failed))
+
+	if len(mismatched)+len(missing)+len(failed) > 0 {
+		os.Exit(exitFailure)
+	}
+	os.Exit(0)
+}
+
+// readSums parses the manifest at path, written by -checksum-manifest or
+// sha256sum.
+func readSums(path string) ([]sumEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []sumEntry
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		entry, ok := parseSumLine(scanner.Text())
+		if !ok {
+			return nil, fmt.Errorf("line %d is not a SHA-256 checksum line", n)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// parseSumLine parses "<hex>  <name>", or "<hex> *<name>" for binary mode,
+// unescaping the name if the line starts with a backslash.
+func parseSumLine(line string) (sumEntry, bool) {
+	escaped := strings.HasPrefix(line, `\`)
+	line = strings.TrimPrefix(line, `\`)
+	hexSum, name, ok := strings.Cut(line, " ")
+	if !ok || len(name) < 2 || (name[0] != ' ' && name[0] != '*') {
+		return sumEntry{}, false
+	}
+	sum, err := hex.DecodeString(hexSum)
+	if err != nil || len(sum) != sha256.Size {
+		return sumEntry{}, false
+	}
+	name = name[1:]
+	if escaped {
+		name = sumUnescaper.Replace(name)
+	}
+	return sumEntry{filepath.FromSlash(name), sum}, true
+}
+
+// sumUnescaper undoes sumEscaper.
+var sumUnescaper = strings.NewReplacer(`\\`, `\`, `\n`, "\n", `\r`, "\r")
+
+// sameSum reports whether the content of path has the SHA-256 sum.
+func sameSum(path string, sum []byte) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return false, err
+	}
+	return bytes.Equal(hash.Sum(nil), sum), nil
+}
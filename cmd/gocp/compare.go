@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/Joonk72/gocp"
+)
+
+// compareMode is the value of -compare, which may be given alone for
+// "size".
+type compareMode string
+
+func (c *compareMode) String() string {
+	return string(*c)
+}
+
+func (c *compareMode) Set(value string) error {
+	if value == "true" {
+		value = "size"
+	}
+	if value != "size" && value != "checksum" {
+		return errors.New(`use "size" or "checksum"`)
+	}
+	*c = compareMode(value)
+	return nil
+}
+
+func (c *compareMode) IsBoolFlag() bool {
+	return true
+}
+
+// compare prints how the target differs from the sources and exits, with
+// exitFailure if they differ.
+func compare(ctx context.Context, opts gocp.Options, mode compareMode) {
+	diff, err := gocp.Compare(ctx, opts, mode == "checksum")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitUsage)
+	}
+
+	for _, path := range diff.OnlyInSource {
+		fmt.Println("only in source: " + path)
+	}
+	for _, path := range diff.OnlyInTarget {
+		fmt.Println("only in target: " + path)
+	}
+	for _, path := range diff.Different {
+		fmt.Println("different:      " + path)
+	}
+	infof("\n%d only in source, %d only in target, %d different, %d identical.\n",
+		len(diff.OnlyInSource), len(diff.OnlyInTarget), len(diff.Different), diff.Same)
+
+	if len(diff.Errors) > 0 {
+		printErrors(diff.Errors)
+	}
+	if len(diff.OnlyInSource)+len(diff.OnlyInTarget)+len(diff.Different)+len(diff.Errors) > 0 {
+		os.Exit(exitFailure)
+	}
+	os.Exit(0)
+}
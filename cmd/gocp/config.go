@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// loadConfig sets the flags named by the keys of the YAML file at path,
+// except those given on the command line, which take precedence. A list sets
+// a repeatable flag once per item.
+func loadConfig(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var values map[string]any
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return err
+	}
+
+	given := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	// sorted, so errors come out the same on every run
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := flag.Lookup(name)
+		if f == nil || name == "config" {
+			return fmt.Errorf("unknown option %q", name)
+		}
+		if given[name] {
+			continue
+		}
+		items, ok := values[name].([]any)
+		if !ok {
+			items = []any{values[name]}
+		}
+		for _, item := range items {
+			if err := f.Value.Set(configValue(item)); err != nil {
+				return fmt.Errorf("invalid %s %q: %v", name, configValue(item), err)
+			}
+		}
+	}
+	return nil
+}
+
+// configValue formats a YAML value the way it would be given as a flag.
+func configValue(value any) string {
+	if t, ok := value.(time.Time); ok {
+		// YAML reads unquoted dates as timestamps
+		return t.Format(time.RFC3339)
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Joonk72/gocp"
+)
+
+// fileLog writes a line per file to a file, as formatted by format. Records
+// format leaves empty are left out.
+type fileLog struct {
+	mu     sync.Mutex
+	file   *os.File
+	w      *bufio.Writer
+	format func(gocp.FileRecord) string
+}
+
+// openFileLog creates the log file at path, replacing an older one.
+func openFileLog(path string, format func(gocp.FileRecord) string) (*fileLog, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &fileLog{file: file, w: bufio.NewWriter(file), format: format}, nil
+}
+
+func (l *fileLog) Record(r gocp.FileRecord) {
+	line := l.format(r)
+	if line == "" {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintln(l.w, line)
+}
+
+// Close flushes the log and makes sure it is on disk.
+func (l *fileLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.w.Flush(); err != nil {
+		l.file.Close()
+		return err
+	}
+	if err := l.file.Sync(); err != nil {
+		l.file.Close()
+		return err
+	}
+	return l.file.Close()
+}
+
+// closeFileLogs closes the files of -logfile and -checksum-manifest that
+// were opened.
+func closeFileLogs(files, sums *fileLog) {
+	if files != nil {
+		if err := files.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot write log file: %v.\n", err)
+		}
+	}
+	if sums != nil {
+		if err := sums.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot write checksum manifest: %v.\n", err)
+		}
+	}
+}
+
+// logLine formats the line of -logfile, tab-separated: result, bytes,
+// duration, source, target and error, if any.
+func logLine(r gocp.FileRecord) string {
+	line := fmt.Sprintf("%s\t%d\t%v\t%s\t%s", r.Result, r.Bytes, r.Duration.Round(time.Microsecond), r.Source, r.Target)
+	if r.Err != nil {
+		line += "\t" + r.Err.Error()
+	}
+	return line
+}
+
+// sumLine returns a function formatting the line of -checksum-manifest for
+// the files copied into target, as sha256sum does, with their path relative
+// to target.
+func sumLine(target string) func(gocp.FileRecord) string {
+	return func(r gocp.FileRecord) string {
+		if r.Checksum == nil {
+			return ""
+		}
+		name, err := filepath.Rel(target, r.Target)
+		if err != nil || name == "." {
+			// a single file copied to a file of its own
+			name = filepath.Base(r.Target)
+		}
+		// like sha256sum, escape the names that would break the line
+		prefix := ""
+		if strings.ContainsAny(name, "\\\n\r") {
+			prefix = `\`
+			name = sumEscaper.Replace(name)
+		}
+		return fmt.Sprintf("%s%x  %s", prefix, r.Checksum, name)
+	}
+}
+
+// sumEscaper escapes a name for a line of -checksum-manifest.
+var sumEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`)
+
+// fileLogs passes every record to each of its logs.
+type fileLogs []gocp.FileLog
+
+func (ls fileLogs) Record(r gocp.FileRecord) {
+	for _, l := range ls {
+		l.Record(r)
+	}
+}
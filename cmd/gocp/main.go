@@ -0,0 +1,939 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/Joonk72/gocp"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/cheggaaa/pb/v3"
+	"github.com/dustin/go-humanize"
+	"github.com/mattn/go-isatty"
+
+	"log/slog"
+	"net/http"
+	_ "net/http/pprof"
+)
+
+// Exit codes reported by the program.
+const (
+	exitFailure = 1 // some files or folders failed to copy
+	exitUsage   = 2 // bad arguments or unusable source
+
+	exitInterrupted = 130 // stopped by a signal
+)
+
+// conflictPolicies maps the values of -conflict to their policy.
+var conflictPolicies = map[string]gocp.ConflictPolicy{
+	"overwrite": gocp.ConflictOverwrite,
+	"skip":      gocp.ConflictSkip,
+	"rename":    gocp.ConflictRename,
+}
+
+// compressions maps the values of -compress to their compression.
+var compressions = map[string]gocp.Compression{
+	"none": gocp.CompressNone,
+	"gzip": gocp.CompressGzip,
+	"zstd": gocp.CompressZstd,
+}
+
+// normalizations maps the values of -normalize to their normal form.
+var normalizations = map[string]gocp.Normalization{
+	"none": gocp.NormalizeNone,
+	"nfc":  gocp.NormalizeNFC,
+	"nfd":  gocp.NormalizeNFD,
+}
+
+// orders maps the values of -sort to their order.
+var orders = map[string]gocp.Order{
+	"none": gocp.OrderScan,
+	"name": gocp.OrderName,
+	"size": gocp.OrderSize,
+}
+
+// reflinkModes maps the values of -reflink to their mode.
+var reflinkModes = map[string]gocp.ReflinkMode{
+	"auto":   gocp.ReflinkAuto,
+	"always": gocp.ReflinkAlways,
+	"never":  gocp.ReflinkNever,
+}
+
+// logLevel selects how much the program prints.
+type logLevel int
+
+const (
+	levelQuiet   logLevel = iota // only errors, on stderr
+	levelNormal                  // status lines, progress bar and summary
+	levelVerbose                 // also every file copied
+)
+
+// level is the log level set by -q and -v.
+var level = levelNormal
+
+// slogLevels maps each log level to the lowest slog level it records.
+var slogLevels = map[logLevel]slog.Level{
+	levelQuiet:   slog.LevelError,
+	levelNormal:  slog.LevelInfo,
+	levelVerbose: slog.LevelDebug,
+}
+
+// logger receives the diagnostics of the program and those of gocp.
+var logger *slog.Logger
+
+// structuredLog is set when -log-format picks records for a machine, which
+// carry every error as it occurs.
+var structuredLog bool
+
+// infof records a status line as an info record.
+func infof(format string, args ...any) {
+	logger.Info(strings.TrimSpace(fmt.Sprintf(format, args...)))
+}
+
+// maxReportedFailures caps how many errors are repeated in the summary.
+const maxReportedFailures = 10
+
+// manifestExt is added to the target path to name the -resume manifest.
+const manifestExt = ".gocp-resume"
+
+// defaultRefresh is how often the progress bars are redrawn by default.
+const defaultRefresh = 200 * time.Millisecond
+
+// defaultBuffer is the -buf copy buffer size, which -nfs-mode enlarges to
+// nfsBuffer with nfsReadAhead more buffers read ahead of the writes.
+const (
+	defaultBuffer = "1MiB"
+	nfsBuffer     = 8 << 20
+	nfsReadAhead  = 4
+)
+
+// progressBar shows gocp progress on a pb progress bar, counting either
+// files or bytes. The workers only add to the counters; the bar catches up
+// with them every refresh, so tiny files don't pay for updating the bar.
+// A bar counting bytes shows the file count as well, and counts files while
+// there are no bytes to copy, so empty files still move it along.
+type progressBar struct {
+	bar     *pb.ProgressBar
+	bytes   bool
+	refresh time.Duration
+
+	doneFiles atomic.Int64
+	files     atomic.Int64 // files to copy
+	written   atomic.Int64
+	total     atomic.Int64 // bytes to write
+	rate      ewmaRate
+	stop      chan struct{}
+	stopped   chan struct{}
+}
+
+func main() {
+	// Define command-line flags
+	var sources patterns
+	flag.Var(&sources, "s", "Source directory or file path (repeatable, to merge several sources)")
+	target := flag.String("t", "", "Target directory path, sftp://[user@]host[:port]/path on a server reached over SFTP,\nor s3://bucket/prefix in an S3 bucket")
+	threads := flag.Uint("mt", 0, "Number of threads to use, each copying a file at a time (0 picks one from the CPU count)")
+	preserve := flag.Bool("p", true, "Preserve file and folder mode bits and timestamps")
+	fileMode := flag.String("mode", "", "Give every copied file these octal permissions, e.g. 0644, whatever the source has")
+	dirMode := flag.String("dir-mode", "", "Give every created folder these octal permissions, e.g. 0755, whatever the source has")
+	var owner bool
+	flag.BoolVar(&owner, "o", false, "Preserve the owner and group of files and folders (Unix, needs privileges)")
+	flag.BoolVar(&owner, "preserve-owner", false, "Preserve the owner and group of files and folders (Unix, needs privileges)")
+	acl := flag.Bool("acl", false, "Preserve access control lists: POSIX ACLs on Linux, the DACL on Windows")
+	xattrs := flag.Bool("xattr", false, "Preserve extended attributes, such as SELinux labels (Linux and macOS)")
+	update := flag.Bool("u", false, "Skip files whose target has the same size and is not older")
+	var dryRun bool
+	flag.BoolVar(&dryRun, "n", false, "Only report what would be copied")
+	flag.BoolVar(&dryRun, "dry-run", false, "Only report what would be copied")
+	follow := flag.Bool("L", false, "Follow symbolic links and copy what they point to")
+	noFollow := flag.Bool("P", false, "Recreate symbolic links in the target (default)")
+	special := flag.Bool("special", false, "Recreate named pipes and device nodes instead of skipping them (Linux and macOS)")
+	dedup := flag.Bool("dedup", false, "Copy files of the same content once and hard link the others to that copy")
+	hardlinks := flag.Bool("hardlinks", false, "Recreate hard links in the target instead of copying the data again")
+	force := flag.Bool("force", false, "Copy even if the files don't seem to fit in the free space of the target")
+	verify := flag.Bool("verify", false, "Verify each copy against a SHA-256 of the source")
+	bufSize := flag.String("buf", defaultBuffer, "Size of the copy buffer used by each thread")
+	splitThreshold := flag.String("split-threshold", "0", "Copy files of at least this size, e.g. 1GB, in parallel ranges, one per thread\n(0 means never)")
+	fsync := flag.Bool("fsync", false, "Flush each file and its folder entry to disk, for durability at some cost in speed")
+	nfsMode := flag.String("nfs-mode", "auto", "Read each file ahead of its writes through larger buffers, for network shares such as\nNFS or SMB: \"on\", \"off\", or \"auto\" when a source or the target is on one")
+	sparse := flag.Bool("sparse", false, "Leave holes in the target where the source has them or holds blocks of zeros,\ninstead of writing the zeros")
+	delta := flag.Bool("delta", false, "Only rewrite the blocks that changed of target files already there with the size of\nthe source, updating them in place")
+	prealloc := flag.Bool("prealloc", false, "Reserve the full size of each file before copying it (Linux and macOS)")
+	maxOpen := flag.Int("max-open", 0, "Cap the files held open at once by the threads (0 picks a cap below the open file\nlimit of the process, -1 means no cap)")
+	limit := flag.String("limit", "0", "Cap the combined write rate per second, e.g. 50MB (0 means unlimited)")
+	var excludes patterns
+	flag.Var(&excludes, "exclude", "Leave out files and folders matching a glob pattern (repeatable)")
+	var includes patterns
+	flag.Var(&includes, "include", "Only copy files matching a glob pattern (repeatable); a file is copied if it\nmatches an -include, or none is given, and matches no -exclude")
+	newerThan := flag.String("newer-than", "", "Only copy files modified after this time, an RFC3339 timestamp or a duration\nago such as 30d or 12h")
+	olderThan := flag.String("older-than", "", "Only copy files modified before this time, like -newer-than")
+	minSize := flag.String("min-size", "0", "Only copy files of at least this size, e.g. 10MB")
+	maxSize := flag.String("max-size", "0", "Only copy files of at most this size, e.g. 10MB (0 means no maximum)")
+	fromList := flag.String("from-list", "", "Only copy the files listed in this file, a path relative to the source per line,\ninstead of walking the whole source")
+	var ignoreFiles patterns
+	flag.Var(&ignoreFiles, "ignore-file", "Honor gitignore-style files with this name, e.g. .gocpignore, in every folder (repeatable)")
+	gitignore := flag.Bool("gitignore", false, "Honor .gitignore files, as with -ignore-file .gitignore")
+	conflict := flag.String("conflict", "overwrite", "What to do with files already in the target: \"overwrite\", \"skip\" or \"rename\"")
+	reflink := flag.String("reflink", "auto", "Clone files on file systems that support it: \"auto\", \"always\" or \"never\"")
+	progress := flag.String("progress", "count", "Drive the progress bar by file \"count\" or by \"bytes\"")
+	refresh := flag.Duration("refresh", defaultRefresh, "How often the progress bar catches up with the copy and is redrawn\n(the bars of -mt above 1 are redrawn by their pool every 200ms)")
+	compress := flag.String("compress", "none", "Compress every file in the target, adding its extension: \"gzip\" (.gz) or \"zstd\" (.zst)")
+	decompress := flag.Bool("decompress", false, "Decompress the .gz and .zst files of the source, dropping their extension")
+	noEmptyDirs := flag.Bool("no-empty-dirs", false, "Only create the folders that end up holding a copied file")
+	watch := flag.Bool("watch", false, "After copying, keep running and copy the changes of the source as they happen")
+	stream := flag.Bool("stream", false, "Start copying as files are found instead of scanning the whole source first;\nthe free space is not checked, and several -s, -flatten, -normalize,\n-dedup, -no-empty-dirs and -sort scan first anyway")
+	normalize := flag.String("normalize", "none", "Convert the names in the target to the Unicode normal form \"nfc\" (Linux, Windows)\nor \"nfd\" (macOS), settling equal names with -conflict")
+	sortBy := flag.String("sort", "none", "Copy the files in the order of their \"name\", or by \"size\" from the smallest;\nwith -mt 1 the copy order is then the same on every run")
+	keepRoot := flag.Bool("keep-root", false, "Copy each source folder into a folder of its own name in the target, rather than\nits contents directly")
+	trailingSlash := flag.Bool("trailing-slash", false, "Decide -keep-root for each source folder as rsync does: \"-s src/\" copies the contents\nof src into the target, and \"-s src\" copies src itself into it")
+	rootName := flag.String("root-name", "", "Copy the sources into a folder of this name in the target, as -keep-root\nwould under another name")
+	noCreate := flag.Bool("no-create", false, "Fail unless the target folder already exists, instead of creating it")
+	flatten := flag.Bool("flatten", false, "Copy every file directly into the target, settling equal names with -conflict")
+	tuneRun := flag.Bool("tune", false, "Copy the source, or a generated dataset without -s, into a scratch folder of the\ntarget, or of the temporary folder without -t, at a sweep of -mt values and report\nthe throughput of each; everything written is removed")
+	tarOut := flag.Bool("tar", false, "Write the source to stdout as a tar archive instead of copying it; -t is not needed")
+	var compareWith compareMode
+	flag.Var(&compareWith, "compare", "Only report how the target differs from the source, by \"size\" or\nby \"checksum\" (-compare alone means size)")
+	var mirror bool
+	flag.BoolVar(&mirror, "mirror", false, "Delete target files and folders missing from the source, except those matching -exclude")
+	flag.BoolVar(&mirror, "delete", false, "Same as -mirror")
+	move := flag.Bool("move", false, "Delete each source file once it was copied, then remove empty source folders")
+	profile := flag.Bool("pprof", false, "Serve the pprof profiler while copying, and the progress as JSON at /stats")
+	profileAddr := flag.String("pprof-addr", "localhost:6060", "Address the pprof profiler and /stats listen on")
+	metricsAddr := flag.String("metrics-addr", "", "Serve Prometheus metrics at /metrics on this address, e.g. localhost:9100")
+	jsonOut := flag.Bool("json", false, "Print the summary as a single JSON object instead, and nothing else on stdout")
+	quiet := flag.Bool("q", false, "Print nothing but errors, which go to stderr")
+	verbose := flag.Bool("v", false, "Also log every file copied with its size")
+	sumManifest := flag.String("checksum-manifest", "", "Write the SHA-256 of every file copied to this file, with its path relative to\nthe target, in the format of sha256sum -c")
+	checkManifest := flag.String("check", "", "Only re-hash the target files listed in this -checksum-manifest and report\nthose that differ or are missing")
+	logFile := flag.String("logfile", "", "Write a line per file to this file: result, bytes, duration, source, target and error")
+	slowest := flag.Int("slowest", 0, "List this many of the files that took the longest at the end, with their size and\nrate (-v lists "+strconv.Itoa(defaultSlowest)+" unless given)")
+	logFormat := flag.String("log-format", "console", "Log for a person (\"console\"), or as slog \"text\" or \"json\" records on stderr")
+	retries := flag.Int("retries", 0, "Retry a file up to this many times after a transient error, with exponential backoff")
+	timeout := flag.Duration("timeout", 0, "Stop the whole run after this long, e.g. 2h (0 means no limit)")
+	fileTimeout := flag.Duration("file-timeout", 0, "Give up on a file taking longer than this, e.g. 5m, and move on (0 means no limit)")
+	resume := flag.Bool("resume", false, "Record the files copied in a manifest and skip those already recorded, to resume\nan interrupted copy; the manifest is deleted once a copy completes without errors")
+	manifestPath := flag.String("manifest", "", "Path of the -resume manifest (default: next to the target, named after it\nwith "+manifestExt+" added)")
+	maxErrors := flag.Int("max-errors", 0, "Abort the run once this many errors occurred, as if something was wrong with all of it\n(0 means no limit)")
+	continueOnError := flag.Bool("continue-on-error", true, "Keep copying the remaining files after an error")
+	strict := flag.Bool("strict", false, "Also fail when source files or folders were skipped for lack of permission to read them")
+	sshKey := flag.String("ssh-key", "", "Private key to log in to an sftp:// target with, besides the SSH agent\n(default: the unencrypted ~/.ssh/id_ed25519, id_ecdsa and id_rsa)")
+	knownHosts := flag.String("known-hosts", "", "File the host key of an sftp:// target is checked against (default: ~/.ssh/known_hosts)")
+	s3Concurrency := flag.Int("s3-concurrency", manager.DefaultUploadConcurrency, "Parts of a file uploaded at once to an s3:// target")
+	configPath := flag.String("config", "", "Read options from this YAML file, keyed by flag name; flags given here take precedence")
+
+	// Parse command-line arguments
+	flag.Parse()
+	if *configPath != "" {
+		if err := loadConfig(*configPath); err != nil {
+			fmt.Printf("Cannot load config %s: %v.\n", *configPath, err)
+			os.Exit(exitUsage)
+		}
+	}
+
+	if *quiet && *verbose {
+		fmt.Println("-q and -v cannot be used together.")
+		os.Exit(exitUsage)
+	}
+	if *quiet || *jsonOut || *tarOut {
+		// the archive owns stdout
+		level = levelQuiet
+	} else if *verbose {
+		level = levelVerbose
+	}
+	var err error
+	logger, err = newLogger(*logFormat, slogLevels[level])
+	if err != nil {
+		fmt.Printf("Invalid log format %q, %v.\n", *logFormat, err)
+		os.Exit(exitUsage)
+	}
+	structuredLog = *logFormat != "console"
+
+	// the pprof import only registers its handlers, nothing listens
+	// unless asked to. /stats is registered once the copy is set up.
+	if *profile {
+		go func() {
+			logger.Error("The pprof profiler stopped", "error", http.ListenAndServe(*profileAddr, nil))
+		}()
+	}
+
+	// Check if required flags are provided
+	if !*tuneRun && ((len(sources) == 0 && *checkManifest == "") || (*target == "" && !*tarOut)) {
+		fmt.Println("Usage: -s <source_directory_or_file> [-s ...] -t <target_directory> [-mt <number_of_threads>]")
+		fmt.Println("       -s <source_directory_or_file> [-s ...] -tar > archive.tar")
+		fmt.Println("       -check <checksum_manifest> -t <target_directory>")
+		fmt.Println("       -tune [-s <source_directory>] [-t <scratch_parent_directory>]")
+		os.Exit(exitUsage)
+	}
+	if *threads == 0 {
+		*threads = gocp.DefaultThreads()
+	}
+	infof("Using %d thread(s).\n", *threads)
+	if *follow && *noFollow {
+		fmt.Println("-L and -P cannot be used together.")
+		os.Exit(exitUsage)
+	}
+	if *keepRoot && *trailingSlash {
+		fmt.Println("-keep-root and -trailing-slash cannot be used together.")
+		os.Exit(exitUsage)
+	}
+	if *progress != "count" && *progress != "bytes" {
+		fmt.Printf("Invalid progress mode %q, use \"count\" or \"bytes\".\n", *progress)
+		os.Exit(exitUsage)
+	}
+	conflictPolicy, ok := conflictPolicies[*conflict]
+	if !ok {
+		fmt.Printf("Invalid conflict policy %q, use \"overwrite\", \"skip\" or \"rename\".\n", *conflict)
+		os.Exit(exitUsage)
+	}
+	reflinkMode, ok := reflinkModes[*reflink]
+	if !ok {
+		fmt.Printf("Invalid reflink mode %q, use \"auto\", \"always\" or \"never\".\n", *reflink)
+		os.Exit(exitUsage)
+	}
+	compression, ok := compressions[*compress]
+	if !ok {
+		fmt.Printf("Invalid compression %q, use \"gzip\" or \"zstd\".\n", *compress)
+		os.Exit(exitUsage)
+	}
+	if compression != gocp.CompressNone && *decompress {
+		fmt.Println("-compress and -decompress cannot be used together.")
+		os.Exit(exitUsage)
+	}
+	normalization, ok := normalizations[*normalize]
+	if !ok {
+		fmt.Printf("Invalid normal form %q, use \"nfc\" or \"nfd\".\n", *normalize)
+		os.Exit(exitUsage)
+	}
+	order, ok := orders[*sortBy]
+	if !ok {
+		fmt.Printf("Invalid sort order %q, use \"name\" or \"size\".\n", *sortBy)
+		os.Exit(exitUsage)
+	}
+	if *gitignore {
+		ignoreFiles = append(ignoreFiles, ".gitignore")
+	}
+	filePerm, err := parseMode(*fileMode)
+	if err != nil {
+		fmt.Printf("Invalid -mode %q: %v.\n", *fileMode, err)
+		os.Exit(exitUsage)
+	}
+	dirPerm, err := parseMode(*dirMode)
+	if err != nil {
+		fmt.Printf("Invalid -dir-mode %q: %v.\n", *dirMode, err)
+		os.Exit(exitUsage)
+	}
+	newer, err := parseTime(*newerThan)
+	if err != nil {
+		fmt.Printf("Invalid -newer-than %q: %v.\n", *newerThan, err)
+		os.Exit(exitUsage)
+	}
+	older, err := parseTime(*olderThan)
+	if err != nil {
+		fmt.Printf("Invalid -older-than %q: %v.\n", *olderThan, err)
+		os.Exit(exitUsage)
+	}
+	minBytes, err := humanize.ParseBytes(*minSize)
+	if err != nil {
+		fmt.Printf("Invalid minimum size %q.\n", *minSize)
+		os.Exit(exitUsage)
+	}
+	maxBytes, err := humanize.ParseBytes(*maxSize)
+	if err != nil {
+		fmt.Printf("Invalid maximum size %q.\n", *maxSize)
+		os.Exit(exitUsage)
+	}
+	splitBytes, err := humanize.ParseBytes(*splitThreshold)
+	if err != nil {
+		fmt.Printf("Invalid split threshold %q.\n", *splitThreshold)
+		os.Exit(exitUsage)
+	}
+	bufferSize, err := humanize.ParseBytes(*bufSize)
+	if err != nil || bufferSize == 0 {
+		fmt.Printf("Invalid buffer size %q.\n", *bufSize)
+		os.Exit(exitUsage)
+	}
+	limitRate, err := humanize.ParseBytes(*limit)
+	if err != nil {
+		fmt.Printf("Invalid limit %q.\n", *limit)
+		os.Exit(exitUsage)
+	}
+	if *slowest < 0 {
+		fmt.Printf("Invalid number of slowest files %d.\n", *slowest)
+		os.Exit(exitUsage)
+	}
+	if *maxErrors < 0 {
+		fmt.Printf("Invalid maximum errors %d.\n", *maxErrors)
+		os.Exit(exitUsage)
+	}
+	var list []string
+	if *fromList != "" {
+		if compareWith != "" || *watch {
+			fmt.Println("-from-list cannot be used with -compare or -watch.")
+			os.Exit(exitUsage)
+		}
+		if list, err = readList(*fromList); err != nil {
+			fmt.Printf("Cannot read file list: %v.\n", err)
+			os.Exit(exitUsage)
+		}
+		if list == nil {
+			// an empty list copies nothing rather than everything
+			list = []string{}
+		}
+	}
+	if *nfsMode != "auto" && *nfsMode != "on" && *nfsMode != "off" {
+		fmt.Printf("Invalid NFS mode %q, use \"auto\", \"on\" or \"off\".\n", *nfsMode)
+		os.Exit(exitUsage)
+	}
+
+	// Use the provided arguments
+	opts := gocp.Options{
+		Sources:  sources,
+		Target:   *target,
+		Threads:  *threads,
+		Preserve: *preserve,
+		Owner:    owner,
+		ACL:      *acl,
+		Xattrs:   *xattrs,
+		FileMode: filePerm,
+		DirMode:  dirPerm,
+		Conflict: conflictPolicy,
+		Reflink:  reflinkMode,
+		Update:   *update,
+		DryRun:   dryRun,
+		Follow:   *follow,
+		Verify:   *verify,
+		Verbose:  level >= levelVerbose,
+		Force:    *force,
+		Fsync:    *fsync,
+		Special:  *special,
+
+		Hardlinks: *hardlinks,
+		Dedup:     *dedup,
+		Exclude:   excludes,
+		Include:   includes,
+
+		IgnoreFiles: ignoreFiles,
+		NewerThan:   newer,
+		OlderThan:   older,
+		MinSize:     minBytes,
+		MaxSize:     maxBytes,
+		List:        list,
+
+		Compress:   compression,
+		Decompress: *decompress,
+
+		NoEmptyDirs: *noEmptyDirs,
+		Stream:      *stream,
+
+		Normalize: normalization,
+		Order:     order,
+
+		KeepRoot: *keepRoot,
+		RootName: *rootName,
+
+		TrailingSlash: *trailingSlash,
+		NoCreate:      *noCreate,
+
+		Flatten: *flatten,
+		Mirror:  mirror,
+		Move:    *move,
+		Retries: *retries,
+
+		FileTimeout: *fileTimeout,
+
+		BufferSize:  int(bufferSize),
+		Preallocate: *prealloc,
+		Sparse:      *sparse,
+		Delta:       *delta,
+
+		SplitThreshold: splitBytes,
+		Limit:          limitRate,
+		MaxOpenFiles:   *maxOpen,
+
+		StopOnError: !*continueOnError,
+		MaxErrors:   *maxErrors,
+		Logger:      logger,
+	}
+	remote := strings.HasPrefix(*target, sftpScheme) || strings.HasPrefix(*target, s3Scheme)
+	if remote && (*checkManifest != "" || compareWith != "" || *watch || *tuneRun) {
+		fmt.Println("-check, -compare, -tune and -watch cannot be used with an sftp:// or s3:// target.")
+		os.Exit(exitUsage)
+	}
+	if strings.HasPrefix(*target, sftpScheme) {
+		server, dir, err := dialSFTP(*target, *threads, *sshKey, *knownHosts)
+		if err != nil {
+			fmt.Printf("Cannot connect to %s: %v.\n", *target, err)
+			os.Exit(exitUsage)
+		}
+		defer server.Close()
+		opts.TargetFS = server
+		opts.Target = dir
+	}
+	if strings.HasPrefix(*target, s3Scheme) {
+		if *s3Concurrency < 1 {
+			fmt.Printf("Invalid S3 concurrency %d.\n", *s3Concurrency)
+			os.Exit(exitUsage)
+		}
+		bucket, prefix, err := openS3(context.Background(), *target, *s3Concurrency)
+		if err != nil {
+			fmt.Printf("Cannot reach %s: %v.\n", *target, err)
+			os.Exit(exitUsage)
+		}
+		opts.TargetFS = bucket
+		opts.Target = prefix
+	}
+	if *nfsMode == "on" || (*nfsMode == "auto" && onNetworkFS(sources, opts)) {
+		opts.ReadAhead = nfsReadAhead
+		if *bufSize == defaultBuffer {
+			opts.BufferSize = nfsBuffer
+		}
+		infof("Reading %d buffer(s) of %s ahead for network file systems.\n", opts.ReadAhead, humanize.IBytes(uint64(opts.BufferSize)))
+	}
+	if level >= levelNormal {
+		switch {
+		case !isatty.IsTerminal(os.Stdout.Fd()):
+			// a bar would fill logs with control characters
+			opts.Progress = &textProgress{}
+		case *threads > 1:
+			opts.Progress = &multiBar{
+				progressBar: progressBar{bytes: *progress == "bytes", refresh: *refresh},
+				workers:     int(*threads),
+			}
+		default:
+			opts.Progress = &progressBar{bytes: *progress == "bytes", refresh: *refresh}
+		}
+	}
+	var stats *liveStats
+	if *profile {
+		stats = &liveStats{next: opts.Progress}
+		opts.Progress = stats
+		http.Handle("/stats", stats)
+	}
+	var metrics *copyMetrics
+	if *metricsAddr != "" {
+		metrics = newCopyMetrics(opts.Progress)
+		opts.Progress = metrics
+		go metrics.serve(*metricsAddr)
+	}
+
+	// on Ctrl-C or a termination request, a copy takes no more files but
+	// finishes those under way; anything else just stops. A second signal
+	// quits right away.
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	drain := make(chan struct{})
+	copying := *checkManifest == "" && compareWith == "" && !*tarOut && !*tuneRun
+	if copying {
+		opts.Drain = drain
+	}
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		if copying {
+			fmt.Fprintln(os.Stderr, "\nStopping once the files being copied are done, signal again to quit now.")
+			close(drain)
+		} else {
+			stop()
+		}
+		<-signals
+		fmt.Fprintln(os.Stderr, "\nQuitting.")
+		os.Exit(exitInterrupted)
+	}()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	if *tuneRun {
+		tune(ctx, opts)
+		return
+	}
+	if *checkManifest != "" {
+		checkSums(ctx, opts, *checkManifest)
+	}
+	if compareWith != "" {
+		compare(ctx, opts, compareWith)
+	}
+	if *tarOut {
+		archive(ctx, opts, *strict)
+	}
+
+	if *resume {
+		opts.Manifest = *manifestPath
+		if opts.Manifest == "" {
+			opts.Manifest = filepath.Clean(*target) + manifestExt
+		}
+	}
+
+	var files, sums *fileLog
+	var records fileLogs
+	if *logFile != "" {
+		files, err = openFileLog(*logFile, logLine)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot create log file: %v.\n", err)
+			os.Exit(exitUsage)
+		}
+		records = append(records, files)
+	}
+	if *sumManifest != "" {
+		sums, err = openFileLog(*sumManifest, sumLine(opts.Target))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot create checksum manifest: %v.\n", err)
+			os.Exit(exitUsage)
+		}
+		records = append(records, sums)
+		opts.Checksums = true
+	}
+	if stats != nil {
+		records = append(records, stats)
+	}
+	if metrics != nil {
+		records = append(records, metrics)
+	}
+	var slow *slowestFiles
+	if n := *slowest; level >= levelNormal && (n > 0 || level >= levelVerbose) {
+		if n == 0 {
+			n = defaultSlowest
+		}
+		slow = &slowestFiles{n: n}
+		records = append(records, slow)
+	}
+	if len(records) > 0 {
+		opts.FileLog = records
+	}
+
+	result, err := gocp.Copy(ctx, opts)
+	if !*watch {
+		closeFileLogs(files, sums)
+	}
+	timedOut := errors.Is(err, context.DeadlineExceeded)
+	interrupted := errors.Is(err, context.Canceled) || errors.Is(err, gocp.ErrDrained)
+	if *jsonOut && (err == nil || interrupted || timedOut) {
+		printJSON(result, opts.DryRun, err != nil)
+		switch {
+		case interrupted:
+			os.Exit(exitInterrupted)
+		case timedOut || len(result.Errors) > 0 || (*strict && len(result.Unreadable) > 0):
+			os.Exit(exitFailure)
+		}
+		return
+	}
+	if interrupted {
+		fmt.Fprintf(os.Stderr, "\nInterrupted: %d of %d file(s) completed.\n", result.Copied, result.Files)
+		os.Exit(exitInterrupted)
+	}
+	if timedOut {
+		fmt.Fprintf(os.Stderr, "\nTimed out after %v: %d of %d file(s) completed.\n", *timeout, result.Copied, result.Files)
+		os.Exit(exitFailure)
+	}
+	if errors.Is(err, gocp.ErrTargetFS) {
+		fmt.Fprintln(os.Stderr, "-o, -acl, -xattr, -hardlinks, -dedup, -special, -mirror and -resume cannot be used with an sftp:// or s3:// target.")
+		os.Exit(exitUsage)
+	}
+	if errors.Is(err, gocp.ErrListSource) {
+		fmt.Fprintln(os.Stderr, "-from-list needs a single source folder.")
+		os.Exit(exitUsage)
+	}
+	if errors.Is(err, gocp.ErrInsufficientSpace) {
+		fmt.Fprintf(os.Stderr, "%v. Use -force to copy anyway.\n", err)
+		os.Exit(exitFailure)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitUsage)
+	}
+
+	infof("\nTotal Elapsed time: %v\n\n", result.Elapsed)
+	infof("Scan: %v, folders: %v, copy: %v.\n", result.ScanElapsed.Round(time.Millisecond),
+		result.FoldersElapsed.Round(time.Millisecond), result.CopyElapsed.Round(time.Millisecond))
+
+	if opts.DryRun {
+		infof("Dry run: %s in %d file(s) would be copied.\n",
+			humanize.IBytes(result.CopiedBytes), result.Copied)
+	} else {
+		infof("Copied %s in %v (%s/s).\n", humanize.IBytes(result.CopiedBytes),
+			result.Elapsed.Round(time.Millisecond), humanize.IBytes(rate(result.CopiedBytes, result.Elapsed)))
+	}
+
+	if result.Skipped > 0 {
+		infof("Skipped %d existing file(s) (%s).\n", result.Skipped, humanize.IBytes(result.SkippedBytes))
+	}
+	if result.Excluded > 0 {
+		infof("Excluded %d file(s) and folder(s).\n", result.Excluded)
+	}
+	if result.Deleted > 0 {
+		if opts.DryRun {
+			infof("Dry run: %d extraneous file(s) and folder(s) would be deleted.\n", result.Deleted)
+		} else {
+			infof("Deleted %d extraneous file(s) and folder(s).\n", result.Deleted)
+		}
+	}
+	if result.Collisions > 0 {
+		// loud even with -q, since files would have clobbered each other
+		fmt.Fprintf(os.Stderr, "Warning: %d file(s) had the same name as another file in the target.\n", result.Collisions)
+	}
+	if result.Linked > 0 {
+		infof("Linked %d file(s) to an earlier copy.\n", result.Linked)
+	}
+	if result.Deduped > 0 {
+		infof("Linked %d duplicate file(s), saving %s.\n", result.Deduped, humanize.IBytes(result.DedupedBytes))
+	}
+	if result.Specials > 0 {
+		infof("Skipped %d special file(s), such as pipes, sockets and devices.\n", result.Specials)
+	}
+	if result.Retried > 0 {
+		infof("Retried %d file(s) after transient errors.\n", result.Retried)
+	}
+	if result.FailedBytes > 0 {
+		infof("Failed to copy %s.\n", humanize.IBytes(result.FailedBytes))
+	}
+	if slow != nil {
+		slow.print()
+	}
+
+	if len(result.Mismatches) > 0 {
+		fmt.Fprintf(os.Stderr, "%d file(s) failed verification:\n", len(result.Mismatches))
+		for _, path := range result.Mismatches {
+			fmt.Fprintln(os.Stderr, "  "+path)
+		}
+	}
+
+	if len(result.Unreadable) > 0 {
+		printUnreadable(result.Unreadable)
+	}
+
+	if len(result.Errors) > 0 {
+		if result.Aborted {
+			if opts.StopOnError {
+				fmt.Fprintln(os.Stderr, "Aborted after the first error.")
+			} else {
+				fmt.Fprintf(os.Stderr, "Aborted after %d errors.\n", opts.MaxErrors)
+			}
+		}
+		printErrors(result.Errors)
+	}
+	if !*watch && (len(result.Errors) > 0 || (*strict && len(result.Unreadable) > 0)) {
+		os.Exit(exitFailure)
+	}
+
+	if *watch {
+		infof("Watching the source for changes, press Ctrl-C to stop.\n")
+		err := gocp.Watch(ctx, opts, 0)
+		closeFileLogs(files, sums)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitFailure)
+		}
+	}
+}
+
+// printErrors lists errs on stderr, up to maxReportedFailures of them. A
+// structured log recorded each of them already and only gets their count.
+func printErrors(errs []*gocp.CopyError) {
+	if structuredLog {
+		logger.Error("Errors occurred", "count", len(errs))
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%d error(s) occurred:\n", len(errs))
+	shown := errs[:min(len(errs), maxReportedFailures)]
+	for _, err := range shown {
+		fmt.Fprintln(os.Stderr, "  "+err.Error())
+	}
+	if len(errs) > len(shown) {
+		fmt.Fprintf(os.Stderr, "  ... and %d more\n", len(errs)-len(shown))
+	}
+}
+
+// printUnreadable lists the paths skipped as unreadable on stderr, up to
+// maxReportedFailures of them, or only logs their count.
+func printUnreadable(paths []string) {
+	if structuredLog {
+		logger.Warn("Skipped unreadable paths", "count", len(paths))
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Skipped %d unreadable file(s) and folder(s):\n", len(paths))
+	shown := paths[:min(len(paths), maxReportedFailures)]
+	for _, path := range shown {
+		fmt.Fprintln(os.Stderr, "  "+path)
+	}
+	if len(paths) > len(shown) {
+		fmt.Fprintf(os.Stderr, "  ... and %d more\n", len(paths)-len(shown))
+	}
+}
+
+// patterns collects the values of a repeatable flag.
+type patterns []string
+
+func (p *patterns) String() string {
+	return strings.Join(*p, ", ")
+}
+
+func (p *patterns) Set(value string) error {
+	*p = append(*p, value)
+	return nil
+}
+
+// parseTime parses an RFC3339 timestamp, or a duration before now that may
+// be given in days, such as "30d". An empty value is the zero time.
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.ParseFloat(days, 64)
+		if err != nil {
+			return time.Time{}, errors.New("not a number of days")
+		}
+		return time.Now().Add(-time.Duration(n * 24 * float64(time.Hour))), nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return time.Time{}, errors.New("not a timestamp or a duration")
+	}
+	return time.Now().Add(-d), nil
+}
+
+// readList reads the paths of -from-list, one per line. Blank lines are
+// skipped, and the rest kept as they are, spaces included.
+func readList(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var list []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := strings.TrimSuffix(scanner.Text(), "\r"); line != "" {
+			list = append(list, line)
+		}
+	}
+	return list, scanner.Err()
+}
+
+// onNetworkFS reports whether one of sources or the target of opts, unless
+// it is remote, is on a network file system.
+func onNetworkFS(sources []string, opts gocp.Options) bool {
+	if opts.TargetFS == nil && opts.Target != "" && gocp.OnNetworkFS(opts.Target) {
+		return true
+	}
+	for _, source := range sources {
+		if gocp.OnNetworkFS(source) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseMode parses octal permission bits such as 0644. An empty value means
+// none are forced.
+func parseMode(value string) (os.FileMode, error) {
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || n == 0 || n > 0777 {
+		return 0, errors.New("use octal permissions from 1 to 0777")
+	}
+	return os.FileMode(n), nil
+}
+
+// rate returns the number of bytes transferred per second.
+func rate(bytes uint64, elapsed time.Duration) uint64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return uint64(float64(bytes) / elapsed.Seconds())
+}
+
+func (p *progressBar) Start(files, bytes uint64) {
+	p.create(files, bytes)
+	p.bar.Start()
+}
+
+// follow updates the bar from done every refresh until flush.
+func (p *progressBar) follow() {
+	p.stop = make(chan struct{})
+	p.stopped = make(chan struct{})
+	go func() {
+		defer close(p.stopped)
+		ticker := time.NewTicker(p.refresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				p.show()
+				p.bar.Set("suffix", p.rate.update(p.written.Load(), p.total.Load(), now))
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+}
+
+// flush stops following done and brings the bar up to date.
+func (p *progressBar) flush() {
+	close(p.stop)
+	<-p.stopped
+	p.show()
+}
+
+// show sets the bar to the counters.
+func (p *progressBar) show() {
+	byBytes := p.bytes && p.total.Load() > 0
+	p.bar.Set(pb.Bytes, byBytes)
+	if !byBytes {
+		p.bar.Set("prefix", "")
+		p.bar.SetTotal(p.files.Load())
+		p.bar.SetCurrent(p.doneFiles.Load())
+		return
+	}
+	p.bar.Set("prefix", fmt.Sprintf("%d/%d file(s)", p.doneFiles.Load(), p.files.Load()))
+	p.bar.SetTotal(p.total.Load())
+	p.bar.SetCurrent(p.written.Load())
+}
+
+// create sets up the progress bar without starting to draw it.
+func (p *progressBar) create(files, bytes uint64) {
+	p.SetTotal(files, bytes)
+	p.bar = pb.New64(0)
+	p.show()
+	p.bar.SetTemplateString(`{{string . "prefix"}} {{counters . }} {{bar . "[" "=" ">" "-" "]" | green}} {{percent . }} {{etime . }} {{speed . "%s/s" ""}} {{string . "suffix"}}`)
+	if p.refresh <= 0 {
+		p.refresh = defaultRefresh
+	}
+	p.bar.SetRefreshRate(p.refresh)
+	p.follow()
+}
+
+func (p *progressBar) SetTotal(files, bytes uint64) {
+	p.total.Store(int64(bytes))
+	p.files.Store(int64(files))
+}
+
+func (p *progressBar) Increment() {
+	p.doneFiles.Add(1)
+}
+
+func (p *progressBar) Add(n int64) {
+	p.written.Add(n)
+}
+
+func (p *progressBar) Finish() {
+	p.flush()
+	p.bar.Finish()
+}
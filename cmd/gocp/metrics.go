@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/Joonk72/gocp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// copyMetrics exports the run to Prometheus for -metrics-addr. Like
+// liveStats, it passes the progress on to the Progress it wraps, if any, and
+// takes the outcome of each file from the file log.
+type copyMetrics struct {
+	next gocp.Progress // may be nil
+
+	files    prometheus.Counter
+	bytes    prometheus.Counter
+	errors   prometheus.Counter
+	duration prometheus.Histogram
+	active   prometheus.Gauge
+	registry *prometheus.Registry
+}
+
+// metricsTracker counts a file as active for copyMetrics until it is done.
+type metricsTracker struct {
+	metrics *copyMetrics
+	next    gocp.FileTracker // may be nil
+}
+
+// newCopyMetrics registers the metrics of a run in front of next.
+func newCopyMetrics(next gocp.Progress) *copyMetrics {
+	m := &copyMetrics{
+		next: next,
+		files: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "gocp",
+			Name:      "files_copied_total",
+			Help:      "Files copied or linked into the target.",
+		}),
+		bytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "gocp",
+			Name:      "bytes_copied_total",
+			Help:      "Bytes written to the target.",
+		}),
+		errors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "gocp",
+			Name:      "errors_total",
+			Help:      "Files that failed to copy.",
+		}),
+		duration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "gocp",
+			Name:      "copy_duration_seconds",
+			Help:      "Time taken to copy each file.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+		}),
+		active: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "gocp",
+			Name:      "active_workers",
+			Help:      "Workers copying a file right now.",
+		}),
+		registry: prometheus.NewRegistry(),
+	}
+	m.registry.MustRegister(m.files, m.bytes, m.errors, m.duration, m.active)
+	return m
+}
+
+// serve answers /metrics on addr until the program ends.
+func (m *copyMetrics) serve(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+	logger.Error("The metrics server stopped", "error", http.ListenAndServe(addr, mux))
+}
+
+func (m *copyMetrics) Start(files, bytes uint64) {
+	if m.next != nil {
+		m.next.Start(files, bytes)
+	}
+}
+
+func (m *copyMetrics) SetTotal(files, bytes uint64) {
+	if totals, ok := m.next.(gocp.TotalProgress); ok {
+		totals.SetTotal(files, bytes)
+	}
+}
+
+func (m *copyMetrics) Increment() {
+	if m.next != nil {
+		m.next.Increment()
+	}
+}
+
+func (m *copyMetrics) Add(n int64) {
+	m.bytes.Add(float64(n))
+	if m.next != nil {
+		m.next.Add(n)
+	}
+}
+
+func (m *copyMetrics) Finish() {
+	if m.next != nil {
+		m.next.Finish()
+	}
+}
+
+func (m *copyMetrics) StartFile(path string, size int64) gocp.FileTracker {
+	m.active.Inc()
+	tracker := metricsTracker{metrics: m}
+	if files, ok := m.next.(gocp.FileProgress); ok {
+		tracker.next = files.StartFile(path, size)
+	}
+	return tracker
+}
+
+func (t metricsTracker) Add(n int64) {
+	if t.next != nil {
+		t.next.Add(n)
+	}
+}
+
+func (t metricsTracker) Done() {
+	t.metrics.active.Dec()
+	if t.next != nil {
+		t.next.Done()
+	}
+}
+
+// Record counts the files copied and failed, so copyMetrics is also a
+// gocp.FileLog.
+func (m *copyMetrics) Record(r gocp.FileRecord) {
+	switch r.Result {
+	case gocp.ResultFailed:
+		m.errors.Inc()
+	case gocp.ResultSkipped:
+	default:
+		m.files.Inc()
+		m.duration.Observe(r.Duration.Seconds())
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Joonk72/gocp"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// s3Scheme starts the -t targets in an S3 bucket.
+const s3Scheme = "s3://"
+
+// errS3Link is returned for the symbolic links of the source, which a
+// bucket can't hold.
+var errS3Link = errors.New("S3 has no symbolic links, use -L to copy what they point to")
+
+// errUploadAborted ends the upload of a file closed without being committed.
+var errUploadAborted = errors.New("upload aborted")
+
+// s3Target writes the target to a bucket, as a gocp.DirectTargetFS. Each
+// file becomes an object keyed by its path, uploaded in parts when large,
+// and folders are only the prefixes of those keys. Objects keep no mode,
+// and their time is that of the upload.
+type s3Target struct {
+	client   *s3.Client
+	uploader *manager.Uploader
+	bucket   string
+}
+
+// parseS3 splits a target such as "s3://bucket/prefix" into the bucket and
+// the key prefix the files go under.
+func parseS3(target string) (bucket, prefix string, err error) {
+	bucket, prefix, _ = strings.Cut(strings.TrimPrefix(target, s3Scheme), "/")
+	if bucket == "" {
+		return "", "", errors.New("no bucket in the target")
+	}
+	return bucket, strings.Trim(prefix, "/"), nil
+}
+
+// openS3 reaches the bucket of target with the credentials of the standard
+// AWS chain, uploading up to concurrency parts of a file at once. It returns
+// the prefix of the target in the bucket as well.
+func openS3(ctx context.Context, target string, concurrency int) (*s3Target, string, error) {
+	bucket, prefix, err := parseS3(target)
+	if err != nil {
+		return nil, "", err
+	}
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, "", fmt.Errorf("Cannot load the AWS configuration: %w", err)
+	}
+	if cfg.Region == "" {
+		// any region tells where the bucket is
+		cfg.Region = "us-east-1"
+		region, err := manager.GetBucketRegion(ctx, s3.NewFromConfig(cfg), bucket)
+		if err != nil {
+			return nil, "", fmt.Errorf("Cannot find the region of the bucket: %w", err)
+		}
+		cfg.Region = region
+	}
+
+	client := s3.NewFromConfig(cfg)
+	t := &s3Target{
+		client: client,
+		uploader: manager.NewUploader(client, func(u *manager.Uploader) {
+			u.Concurrency = concurrency
+		}),
+		bucket: bucket,
+	}
+	if prefix == "" {
+		prefix = "."
+	}
+	return t, prefix, nil
+}
+
+// key returns the key of the object at name, or "" for the root of the
+// bucket.
+func (t *s3Target) key(name string) string {
+	key := filepath.ToSlash(filepath.Clean(name))
+	if key == "." {
+		return ""
+	}
+	return strings.TrimPrefix(key, "/")
+}
+
+// upload is a file being uploaded to the bucket, through a pipe read by the
+// uploader.
+type upload struct {
+	pipe *io.PipeWriter
+	done chan error
+}
+
+func (t *s3Target) CreateDirect(name string, _ fs.FileMode) (gocp.DirectFile, error) {
+	reader, writer := io.Pipe()
+	u := &upload{writer, make(chan error, 1)}
+	go func() {
+		_, err := t.uploader.Upload(context.Background(), &s3.PutObjectInput{
+			Bucket: aws.String(t.bucket),
+			Key:    aws.String(t.key(name)),
+			Body:   reader,
+		})
+		// unblock the writes of a failed upload
+		reader.CloseWithError(err)
+		u.done <- err
+	}()
+	return u, nil
+}
+
+func (u *upload) Write(p []byte) (int, error) {
+	return u.pipe.Write(p)
+}
+
+// Commit completes the upload and waits for the object to be stored.
+func (u *upload) Commit() error {
+	u.pipe.Close()
+	err := <-u.done
+	u.done = nil
+	return err
+}
+
+// Close aborts the upload unless it was committed.
+func (u *upload) Close() error {
+	if u.done == nil {
+		return nil
+	}
+	u.pipe.CloseWithError(errUploadAborted)
+	<-u.done
+	u.done = nil
+	return nil
+}
+
+// committedUpload is an upload that is committed when closed, for Create.
+type committedUpload struct {
+	*upload
+}
+
+func (u committedUpload) Close() error {
+	return u.Commit()
+}
+
+func (t *s3Target) Create(name string, perm fs.FileMode) (io.WriteCloser, error) {
+	file, err := t.CreateDirect(name, perm)
+	if err != nil {
+		return nil, err
+	}
+	return committedUpload{file.(*upload)}, nil
+}
+
+func (t *s3Target) Open(name string) (fs.File, error) {
+	info, err := t.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	object, err := t.client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(t.bucket),
+		Key:    aws.String(t.key(name)),
+	})
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return objectFile{object.Body, info}, nil
+}
+
+// Stat tells an object from a folder, which is there as long as some key
+// starts with its prefix.
+func (t *s3Target) Stat(name string) (fs.FileInfo, error) {
+	ctx := context.Background()
+	key := t.key(name)
+	if key != "" {
+		head, err := t.client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(t.bucket),
+			Key:    aws.String(key),
+		})
+		if err == nil {
+			return objectInfo{path.Base(key), aws.ToInt64(head.ContentLength), aws.ToTime(head.LastModified), false}, nil
+		}
+		var notFound *types.NotFound
+		if !errors.As(err, &notFound) {
+			return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+		}
+	}
+
+	prefix := key
+	if prefix != "" {
+		prefix += "/"
+	}
+	list, err := t.client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(t.bucket),
+		Prefix:  aws.String(prefix),
+		MaxKeys: aws.Int32(1),
+	})
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	if key != "" && len(list.Contents) == 0 {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return objectInfo{path.Base(key), 0, time.Time{}, true}, nil
+}
+
+func (t *s3Target) Lstat(name string) (fs.FileInfo, error) {
+	return t.Stat(name)
+}
+
+// MkdirAll does nothing, since folders are only the prefixes of keys.
+func (t *s3Target) MkdirAll(string, fs.FileMode) error {
+	return nil
+}
+
+// Rename copies oldname to newname within the bucket and deletes it.
+func (t *s3Target) Rename(oldname, newname string) error {
+	_, err := t.client.CopyObject(context.Background(), &s3.CopyObjectInput{
+		Bucket:     aws.String(t.bucket),
+		Key:        aws.String(t.key(newname)),
+		CopySource: aws.String((&url.URL{Path: t.bucket + "/" + t.key(oldname)}).EscapedPath()),
+	})
+	if err != nil {
+		return err
+	}
+	return t.Remove(oldname)
+}
+
+func (t *s3Target) Remove(name string) error {
+	_, err := t.client.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+		Bucket: aws.String(t.bucket),
+		Key:    aws.String(t.key(name)),
+	})
+	return err
+}
+
+// Chmod and Chtimes do nothing, since objects keep neither.
+
+func (t *s3Target) Chmod(string, fs.FileMode) error {
+	return nil
+}
+
+func (t *s3Target) Chtimes(string, time.Time, time.Time) error {
+	return nil
+}
+
+func (t *s3Target) Symlink(string, string) error {
+	return errS3Link
+}
+
+// objectInfo describes an object or a folder of the bucket.
+type objectInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (i objectInfo) Name() string       { return i.name }
+func (i objectInfo) Size() int64        { return i.size }
+func (i objectInfo) ModTime() time.Time { return i.modTime }
+func (i objectInfo) IsDir() bool        { return i.dir }
+func (i objectInfo) Sys() any           { return nil }
+
+func (i objectInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0o755
+	}
+	return 0o644
+}
+
+// objectFile reads an object back, to verify or hash it.
+type objectFile struct {
+	io.ReadCloser
+	info fs.FileInfo
+}
+
+func (f objectFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
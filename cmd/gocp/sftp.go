@@ -0,0 +1,273 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sftpScheme starts the -t targets on a server reached over SFTP.
+const sftpScheme = "sftp://"
+
+// defaultKeys are the private keys of ~/.ssh tried when -ssh-key isn't given.
+var defaultKeys = []string{"id_ed25519", "id_ecdsa", "id_rsa"}
+
+// sftpTarget writes the target to a server over SFTP, as a gocp.TargetFS.
+// It opens a session per worker over a single SSH connection and hands them
+// out in turn, so the workers don't wait on each other's requests.
+type sftpTarget struct {
+	conn     *ssh.Client
+	sessions []*sftp.Client
+	next     atomic.Uint64
+}
+
+// parseSFTP splits a target such as "sftp://user@host:/backup" or
+// "sftp://host:2222/backup" into the address of the server, the user to log
+// in as and the path of the target on the server. The user defaults to the
+// local one, the port to 22 and the path to the home folder.
+func parseSFTP(target string) (addr, login, dir string, err error) {
+	rest := strings.TrimPrefix(target, sftpScheme)
+	host, dir, found := strings.Cut(rest, "/")
+	if found {
+		dir = "/" + dir
+	} else {
+		dir = "."
+	}
+	if at := strings.LastIndex(host, "@"); at >= 0 {
+		login, host = host[:at], host[at+1:]
+	}
+	if login == "" {
+		current, err := user.Current()
+		if err != nil {
+			return "", "", "", fmt.Errorf("Cannot tell the user to log in as: %w", err)
+		}
+		login = current.Username
+	}
+
+	host = strings.TrimSuffix(host, ":")
+	port := "22"
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		host, port = h, p
+	}
+	if host == "" {
+		return "", "", "", errors.New("no host in the target")
+	}
+	return net.JoinHostPort(host, port), login, dir, nil
+}
+
+// dialSFTP connects to the server of target with sessions SFTP sessions,
+// authenticating with the SSH agent and keyFile, or the default keys, and
+// checking the server against knownHosts. It returns the path of the
+// target on the server as well.
+func dialSFTP(target string, sessions uint, keyFile, knownHosts string) (*sftpTarget, string, error) {
+	addr, login, dir, err := parseSFTP(target)
+	if err != nil {
+		return nil, "", err
+	}
+	home, _ := os.UserHomeDir()
+	if knownHosts == "" {
+		knownHosts = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeys, err := knownhosts.New(knownHosts)
+	if err != nil {
+		return nil, "", fmt.Errorf("Cannot read known hosts: %w", err)
+	}
+	auth, err := sshAuth(home, keyFile)
+	if err != nil {
+		return nil, "", err
+	}
+
+	config := &ssh.ClientConfig{
+		User:              login,
+		Auth:              auth,
+		HostKeyCallback:   hostKeys,
+		HostKeyAlgorithms: knownAlgorithms(hostKeys, addr),
+		Timeout:           30 * time.Second,
+	}
+	conn, err := ssh.Dial("tcp", addr, config)
+	if err != nil {
+		return nil, "", err
+	}
+
+	t := &sftpTarget{conn: conn}
+	for i := uint(0); i < max(sessions, 1); i++ {
+		session, err := sftp.NewClient(conn, sftp.UseConcurrentWrites(true))
+		if err != nil {
+			t.Close()
+			return nil, "", fmt.Errorf("Cannot start an SFTP session: %w", err)
+		}
+		t.sessions = append(t.sessions, session)
+	}
+	return t, dir, nil
+}
+
+// sshAuth returns the ways to log in: the keys of the SSH agent, if one
+// runs, then keyFile or the default keys of home found unencrypted.
+func sshAuth(home, keyFile string) ([]ssh.AuthMethod, error) {
+	var auth []ssh.AuthMethod
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			auth = append(auth, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+
+	var signers []ssh.Signer
+	if keyFile != "" {
+		signer, err := readKey(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read key %s: %w", keyFile, err)
+		}
+		signers = append(signers, signer)
+	} else {
+		for _, name := range defaultKeys {
+			path := filepath.Join(home, ".ssh", name)
+			signer, err := readKey(path)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				// most likely protected by a passphrase, left to the agent
+				logger.Debug("Skipping key", "path", path, "error", err)
+				continue
+			}
+			signers = append(signers, signer)
+		}
+	}
+	if len(signers) > 0 {
+		auth = append(auth, ssh.PublicKeys(signers...))
+	}
+	if len(auth) == 0 {
+		return nil, errors.New("no SSH agent or key to log in with, use -ssh-key")
+	}
+	return auth, nil
+}
+
+// readKey parses the private key in path.
+func readKey(path string) (ssh.Signer, error) {
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.ParsePrivateKey(key)
+}
+
+// knownAlgorithms returns the types of the keys known for addr, so the
+// server is asked for one of those rather than one known_hosts can't check.
+func knownAlgorithms(hostKeys ssh.HostKeyCallback, addr string) []string {
+	// a key that is no one's makes the callback list the known ones
+	tcpAddr, _ := net.ResolveTCPAddr("tcp", addr)
+	if tcpAddr == nil {
+		tcpAddr = &net.TCPAddr{}
+	}
+	var keyErr *knownhosts.KeyError
+	if err := hostKeys(addr, tcpAddr, unknownKey{}); !errors.As(err, &keyErr) {
+		return nil
+	}
+	var algorithms []string
+	for _, known := range keyErr.Want {
+		switch known.Key.Type() {
+		case ssh.KeyAlgoRSA:
+			algorithms = append(algorithms, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA)
+		default:
+			algorithms = append(algorithms, known.Key.Type())
+		}
+	}
+	return algorithms
+}
+
+// unknownKey is a host key that matches nothing in known_hosts.
+type unknownKey struct{}
+
+func (unknownKey) Type() string                        { return "unknown" }
+func (unknownKey) Marshal() []byte                     { return []byte("unknown") }
+func (unknownKey) Verify([]byte, *ssh.Signature) error { return errors.New("unknown key") }
+
+// session returns the SFTP session to send the next request through.
+func (t *sftpTarget) session() *sftp.Client {
+	return t.sessions[t.next.Add(1)%uint64(len(t.sessions))]
+}
+
+// Close ends the sessions and the connection.
+func (t *sftpTarget) Close() error {
+	for _, session := range t.sessions {
+		session.Close()
+	}
+	return t.conn.Close()
+}
+
+// the paths handed to sftpTarget are built with path/filepath, while the
+// server takes slash separated ones
+
+func (t *sftpTarget) Create(name string, perm fs.FileMode) (io.WriteCloser, error) {
+	file, err := t.session().OpenFile(filepath.ToSlash(name), os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return nil, err
+	}
+	// the server creates it with its own default mode
+	if err := file.Chmod(perm); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+func (t *sftpTarget) Open(name string) (fs.File, error) {
+	return t.session().Open(filepath.ToSlash(name))
+}
+
+func (t *sftpTarget) Stat(name string) (fs.FileInfo, error) {
+	return t.session().Stat(filepath.ToSlash(name))
+}
+
+func (t *sftpTarget) Lstat(name string) (fs.FileInfo, error) {
+	return t.session().Lstat(filepath.ToSlash(name))
+}
+
+func (t *sftpTarget) MkdirAll(name string, _ fs.FileMode) error {
+	return t.session().MkdirAll(filepath.ToSlash(name))
+}
+
+// Rename replaces newname in one step where the server supports it, and
+// removes it first otherwise, since a plain SFTP rename fails on an existing
+// file.
+func (t *sftpTarget) Rename(oldname, newname string) error {
+	session := t.session()
+	oldname, newname = filepath.ToSlash(oldname), filepath.ToSlash(newname)
+	if _, ok := session.HasExtension("posix-rename@openssh.com"); ok {
+		return session.PosixRename(oldname, newname)
+	}
+	if err := session.Remove(newname); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return session.Rename(oldname, newname)
+}
+
+func (t *sftpTarget) Remove(name string) error {
+	return t.session().Remove(filepath.ToSlash(name))
+}
+
+func (t *sftpTarget) Chmod(name string, mode fs.FileMode) error {
+	return t.session().Chmod(filepath.ToSlash(name), mode)
+}
+
+func (t *sftpTarget) Chtimes(name string, atime, mtime time.Time) error {
+	return t.session().Chtimes(filepath.ToSlash(name), atime, mtime)
+}
+
+func (t *sftpTarget) Symlink(oldname, newname string) error {
+	// the link keeps what it points to as it was read
+	return t.session().Symlink(oldname, filepath.ToSlash(newname))
+}
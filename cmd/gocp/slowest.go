@@ -0,0 +1,87 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Joonk72/gocp"
+	"github.com/dustin/go-humanize"
+)
+
+// defaultSlowest is how many of the slowest files -v reports when -slowest
+// isn't given.
+const defaultSlowest = 10
+
+// slowestFiles keeps the files of the run that took the longest, for
+// -slowest, as a gocp.FileLog. They are held in a heap with the quickest of
+// them on top, which is the one dropped for a slower file.
+type slowestFiles struct {
+	n     int
+	mu    sync.Mutex
+	files fileDurations
+}
+
+// fileDurations is a min-heap of records by duration.
+type fileDurations []gocp.FileRecord
+
+func (d fileDurations) Len() int           { return len(d) }
+func (d fileDurations) Less(i, j int) bool { return d[i].Duration < d[j].Duration }
+func (d fileDurations) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+func (d *fileDurations) Push(x any)        { *d = append(*d, x.(gocp.FileRecord)) }
+func (d *fileDurations) Pop() any {
+	old := *d
+	last := old[len(old)-1]
+	*d = old[:len(old)-1]
+	return last
+}
+
+// Record keeps r if it is among the n slowest so far. Skipped files took no
+// copying, so they aren't.
+func (s *slowestFiles) Record(r gocp.FileRecord) {
+	if r.Result == gocp.ResultSkipped {
+		return
+	}
+	r.Checksum = nil
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case len(s.files) < s.n:
+		heap.Push(&s.files, r)
+	case r.Duration > s.files[0].Duration:
+		s.files[0] = r
+		heap.Fix(&s.files, 0)
+	}
+}
+
+// print lists the files kept, the slowest first, with their size and the
+// rate they were copied at. A structured log gets a record for each.
+func (s *slowestFiles) print() {
+	s.mu.Lock()
+	files := append(fileDurations(nil), s.files...)
+	s.mu.Unlock()
+	if len(files) == 0 {
+		return
+	}
+	sort.Sort(sort.Reverse(files))
+
+	if !structuredLog {
+		fmt.Printf("Slowest %d file(s):\n", len(files))
+	}
+	for _, file := range files {
+		size := humanize.IBytes(uint64(file.Bytes))
+		speed := humanize.IBytes(rate(uint64(file.Bytes), file.Duration)) + "/s"
+		if structuredLog {
+			logger.Info("Slow file", "path", file.Source, "duration", file.Duration, "size", size,
+				"rate", speed, "result", file.Result.String())
+			continue
+		}
+		line := fmt.Sprintf("  %8v  %9s  %11s  %s", file.Duration.Round(time.Millisecond), size, speed, file.Source)
+		if file.Result == gocp.ResultFailed {
+			line += " (failed)"
+		}
+		fmt.Println(line)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Joonk72/gocp"
+)
+
+// maxRecentErrors caps how many of the latest errors /stats reports.
+const maxRecentErrors = 20
+
+// liveStats follows the run for the /stats endpoint of the debug server. It
+// sits in front of the Progress shown on the terminal, if any, and passes
+// everything on to it, and takes the failed files from the file log.
+type liveStats struct {
+	next gocp.Progress // may be nil
+
+	files, bytes atomic.Uint64
+	doneFiles    atomic.Uint64
+	doneBytes    atomic.Uint64
+	active       atomic.Int64 // files being copied right now
+
+	mu      sync.Mutex
+	started time.Time
+	rate    ewmaRate
+	errors  []jsonError // the latest maxRecentErrors, oldest first
+}
+
+// statsTracker counts a file as active for liveStats until it is done.
+type statsTracker struct {
+	stats *liveStats
+	next  gocp.FileTracker // may be nil
+}
+
+// jsonStats is the body of a /stats response.
+type jsonStats struct {
+	Files        uint64      `json:"files"`
+	FilesDone    uint64      `json:"files_done"`
+	Bytes        uint64      `json:"bytes"`
+	BytesDone    uint64      `json:"bytes_done"`
+	ActiveFiles  int64       `json:"active_files"`
+	Throughput   uint64      `json:"bytes_per_second"`
+	Elapsed      float64     `json:"elapsed_seconds"`
+	RecentErrors []jsonError `json:"recent_errors"`
+}
+
+func (s *liveStats) Start(files, bytes uint64) {
+	s.mu.Lock()
+	s.started = time.Now()
+	// the first rate then covers the time since the start
+	s.rate.update(0, 0, s.started)
+	s.mu.Unlock()
+	s.files.Store(files)
+	s.bytes.Store(bytes)
+	if s.next != nil {
+		s.next.Start(files, bytes)
+	}
+}
+
+func (s *liveStats) SetTotal(files, bytes uint64) {
+	s.files.Store(files)
+	s.bytes.Store(bytes)
+	if totals, ok := s.next.(gocp.TotalProgress); ok {
+		totals.SetTotal(files, bytes)
+	}
+}
+
+func (s *liveStats) Increment() {
+	s.doneFiles.Add(1)
+	if s.next != nil {
+		s.next.Increment()
+	}
+}
+
+func (s *liveStats) Add(n int64) {
+	s.doneBytes.Add(uint64(n))
+	if s.next != nil {
+		s.next.Add(n)
+	}
+}
+
+func (s *liveStats) Finish() {
+	if s.next != nil {
+		s.next.Finish()
+	}
+}
+
+func (s *liveStats) StartFile(path string, size int64) gocp.FileTracker {
+	s.active.Add(1)
+	tracker := statsTracker{stats: s}
+	if files, ok := s.next.(gocp.FileProgress); ok {
+		tracker.next = files.StartFile(path, size)
+	}
+	return tracker
+}
+
+func (t statsTracker) Add(n int64) {
+	if t.next != nil {
+		t.next.Add(n)
+	}
+}
+
+func (t statsTracker) Done() {
+	t.stats.active.Add(-1)
+	if t.next != nil {
+		t.next.Done()
+	}
+}
+
+// Record keeps the files that failed, so liveStats is also a gocp.FileLog.
+func (s *liveStats) Record(r gocp.FileRecord) {
+	if r.Err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.errors) == maxRecentErrors {
+		s.errors = append(s.errors[:0], s.errors[1:]...)
+	}
+	s.errors = append(s.errors, jsonError{"copying file", r.Source, r.Err.Error()})
+}
+
+// ServeHTTP answers /stats with the progress so far as a jsonStats.
+func (s *liveStats) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	stats := jsonStats{
+		Files:       s.files.Load(),
+		FilesDone:   s.doneFiles.Load(),
+		Bytes:       s.bytes.Load(),
+		BytesDone:   s.doneBytes.Load(),
+		ActiveFiles: s.active.Load(),
+	}
+
+	s.mu.Lock()
+	if !s.started.IsZero() {
+		now := time.Now()
+		stats.Elapsed = now.Sub(s.started).Seconds()
+		s.rate.update(int64(stats.BytesDone), int64(stats.Bytes), now)
+		stats.Throughput = uint64(s.rate.rate)
+	}
+	stats.RecentErrors = append([]jsonError{}, s.errors...)
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(stats)
+}
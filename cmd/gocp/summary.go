@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/Joonk72/gocp"
+)
+
+// jsonSummary is the result of a run as printed by -json.
+type jsonSummary struct {
+	Files          uint64      `json:"files"`
+	Folders        uint64      `json:"folders"`
+	Bytes          uint64      `json:"bytes"`
+	Copied         uint64      `json:"copied"`
+	CopiedBytes    uint64      `json:"copied_bytes"`
+	Skipped        uint64      `json:"skipped"`
+	SkippedBytes   uint64      `json:"skipped_bytes"`
+	Excluded       uint64      `json:"excluded"`
+	Deleted        uint64      `json:"deleted"`
+	Retried        uint64      `json:"retried"`
+	Specials       uint64      `json:"specials"`
+	Linked         uint64      `json:"linked"`
+	Collisions     uint64      `json:"collisions"`
+	Deduped        uint64      `json:"deduped"`
+	DedupedBytes   uint64      `json:"deduped_bytes"`
+	Failed         int         `json:"failed"`
+	FailedBytes    uint64      `json:"failed_bytes"`
+	Mismatches     []string    `json:"mismatches"`
+	Unreadable     []string    `json:"unreadable"`
+	Errors         []jsonError `json:"errors"`
+	Aborted        bool        `json:"aborted"`
+	Interrupted    bool        `json:"interrupted"`
+	DryRun         bool        `json:"dry_run"`
+	Elapsed        float64     `json:"elapsed_seconds"`
+	ScanElapsed    float64     `json:"scan_seconds"`
+	FoldersElapsed float64     `json:"folders_seconds"`
+	CopyElapsed    float64     `json:"copy_seconds"`
+	Throughput     uint64      `json:"bytes_per_second"`
+}
+
+// jsonError is a gocp.CopyError in a jsonSummary.
+type jsonError struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// printJSON writes the summary of result to stdout as a single JSON object.
+func printJSON(result gocp.Result, dryRun, interrupted bool) {
+	summary := jsonSummary{
+		Files:        result.Files,
+		Folders:      result.Folders,
+		Bytes:        result.Bytes,
+		Copied:       result.Copied,
+		CopiedBytes:  result.CopiedBytes,
+		Skipped:      result.Skipped,
+		SkippedBytes: result.SkippedBytes,
+		Excluded:     result.Excluded,
+		Deleted:      result.Deleted,
+		Retried:      result.Retried,
+		Specials:     result.Specials,
+		Linked:       result.Linked,
+		Collisions:   result.Collisions,
+		Deduped:      result.Deduped,
+		DedupedBytes: result.DedupedBytes,
+		Failed:       len(result.Errors),
+		FailedBytes:  result.FailedBytes,
+		Mismatches:   result.Mismatches,
+		Unreadable:   result.Unreadable,
+		Errors:       []jsonError{},
+		Aborted:      result.Aborted,
+		Interrupted:  interrupted,
+		DryRun:       dryRun,
+		Elapsed:      result.Elapsed.Seconds(),
+		Throughput:   rate(result.CopiedBytes, result.Elapsed),
+
+		ScanElapsed:    result.ScanElapsed.Seconds(),
+		FoldersElapsed: result.FoldersElapsed.Seconds(),
+		CopyElapsed:    result.CopyElapsed.Seconds(),
+	}
+	if summary.Mismatches == nil {
+		summary.Mismatches = []string{}
+	}
+	if summary.Unreadable == nil {
+		summary.Unreadable = []string{}
+	}
+	for _, err := range result.Errors {
+		summary.Errors = append(summary.Errors, jsonError{err.Op, err.Path, err.Err.Error()})
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(summary)
+}
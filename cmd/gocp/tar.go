@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/Joonk72/gocp"
+	"github.com/mattn/go-isatty"
+)
+
+// archive writes the sources to stdout as a tar archive and exits, with
+// exitFailure if anything failed, or was left out as unreadable and strict
+// is set.
+func archive(ctx context.Context, opts gocp.Options, strict bool) {
+	if isatty.IsTerminal(os.Stdout.Fd()) {
+		fmt.Fprintln(os.Stderr, "Refusing to write a tar archive to a terminal, redirect stdout.")
+		os.Exit(exitUsage)
+	}
+
+	result, err := gocp.Tar(ctx, opts, os.Stdout)
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintf(os.Stderr, "\nInterrupted: %d of %d file(s) archived.\n", result.Copied, result.Files)
+		os.Exit(exitInterrupted)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitFailure)
+	}
+	if len(result.Unreadable) > 0 {
+		printUnreadable(result.Unreadable)
+	}
+	if len(result.Errors) > 0 {
+		printErrors(result.Errors)
+		os.Exit(exitFailure)
+	}
+	if strict && len(result.Unreadable) > 0 {
+		os.Exit(exitFailure)
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/Joonk72/gocp"
+	"github.com/dustin/go-humanize"
+)
+
+// the synthetic dataset of -tune, in tuneFolders folders: many small files
+// and a few large ones, about 320 MiB in all
+const (
+	tuneFolders    = 16
+	tuneSmallFiles = 1024
+	tuneSmallSize  = 64 << 10
+	tuneLargeFiles = 16
+	tuneLargeSize  = 16 << 20
+)
+
+// tuneThreads returns the thread counts -tune tries: the powers of two up
+// to four times the CPUs, and at least up to 16.
+func tuneThreads() []uint {
+	var counts []uint
+	for n := uint(1); n <= uint(max(4*runtime.NumCPU(), 16)); n *= 2 {
+		counts = append(counts, n)
+	}
+	return counts
+}
+
+// tune copies the sources, or a synthetic dataset if there are none, into a
+// scratch folder of the target, or of the temporary folder, once per thread
+// count of tuneThreads, prints the throughput of each and exits. The sources
+// are only read, and what was written is removed.
+func tune(ctx context.Context, opts gocp.Options) {
+	scratchParent := opts.Target
+	if scratchParent == "" {
+		scratchParent = os.TempDir()
+	}
+	scratch, err := os.MkdirTemp(scratchParent, ".gocp-tune-")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot create scratch folder: %v.\n", err)
+		os.Exit(exitUsage)
+	}
+	defer os.RemoveAll(scratch)
+
+	if len(opts.Sources) == 0 {
+		dataset := filepath.Join(scratch, "source")
+		infof("Writing a %s dataset to copy...\n", humanize.IBytes(tuneSmallFiles*tuneSmallSize+tuneLargeFiles*tuneLargeSize))
+		if err := writeDataset(ctx, dataset); err != nil {
+			tuneFailed(scratch, fmt.Errorf("Cannot write the dataset: %w", err))
+		}
+		opts.Sources = []string{dataset}
+	}
+
+	// nothing may touch the sources, and every run starts from scratch
+	opts.Move, opts.Mirror, opts.DryRun, opts.Update = false, false, false, false
+	opts.Manifest = ""
+	opts.Conflict = gocp.ConflictOverwrite
+	opts.Progress, opts.FileLog, opts.Drain, opts.Logger = nil, nil, nil, nil
+	opts.Force = true
+
+	run := func(threads uint) (gocp.Result, error) {
+		opts.Threads = threads
+		opts.Target = filepath.Join(scratch, "target")
+		defer os.RemoveAll(opts.Target)
+		result, err := gocp.Copy(ctx, opts)
+		if err == nil && len(result.Errors) > 0 {
+			err = result.Errors[0]
+		}
+		return result, err
+	}
+
+	// a first run reads the sources into the cache, so every count is
+	// timed alike
+	counts := tuneThreads()
+	infof("Warming up...\n")
+	if _, err := run(counts[0]); err != nil {
+		tuneFailed(scratch, err)
+	}
+
+	var best uint
+	var bestRate uint64
+	for _, threads := range counts {
+		result, err := run(threads)
+		if err != nil {
+			tuneFailed(scratch, err)
+		}
+		speed := rate(result.CopiedBytes, result.CopyElapsed)
+		infof("%d thread(s): %s/s, %d file(s) in %v\n", threads, humanize.IBytes(speed), result.Copied,
+			result.CopyElapsed.Round(time.Millisecond))
+		if speed > bestRate {
+			best, bestRate = threads, speed
+		}
+	}
+	infof("\nFastest with -mt %d, at %s/s.\n", best, humanize.IBytes(bestRate))
+}
+
+// tuneFailed reports the error that ended -tune, cleans up and exits.
+func tuneFailed(scratch string, err error) {
+	os.RemoveAll(scratch)
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintln(os.Stderr, "\nInterrupted.")
+		os.Exit(exitInterrupted)
+	}
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(exitFailure)
+}
+
+// writeDataset writes the synthetic dataset of -tune to dir, of random
+// content so that nothing compresses or deduplicates it.
+func writeDataset(ctx context.Context, dir string) error {
+	for i := 0; i < tuneSmallFiles+tuneLargeFiles; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		folder := filepath.Join(dir, fmt.Sprintf("folder%02d", i%tuneFolders))
+		if err := os.MkdirAll(folder, 0o755); err != nil {
+			return err
+		}
+		name, size := fmt.Sprintf("small%04d", i), int64(tuneSmallSize)
+		if i >= tuneSmallFiles {
+			name, size = fmt.Sprintf("large%02d", i-tuneSmallFiles), tuneLargeSize
+		}
+		if err := writeRandom(filepath.Join(folder, name), size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeRandom creates path with size random bytes.
+func writeRandom(path string, size int64) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(file, rand.Reader, size); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,166 @@
+package gocp
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Diff lists how the target differs from the sources, by paths relative to
+// them.
+type Diff struct {
+	OnlyInSource []string // files and folders missing from the target
+	OnlyInTarget []string // files and folders of the target missing from the sources
+	Different    []string // paths whose kind, size, link or checksum differ
+	Same         uint64   // paths found the same on both sides
+
+	// Errors holds every error that occurred, in no particular order.
+	Errors []*CopyError
+}
+
+// Compare walks the sources and the target of opts, applying the same
+// filters as Copy, and reports how they differ without writing anything.
+// Files of the same size are told apart by their SHA-256 when checksum is
+// set.
+func Compare(ctx context.Context, opts Options, checksum bool) (Diff, error) {
+	if opts.Threads == 0 {
+		opts.Threads = DefaultThreads()
+	}
+	if opts.Logger == nil {
+		opts.Logger = discardLogger
+	}
+	if err := opts.applyRootName(); err != nil {
+		return Diff{}, err
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	rep, err := newReport(&opts, cancel)
+	if err != nil {
+		return Diff{}, err
+	}
+
+	if opts.TargetFS != nil {
+		return Diff{}, ErrTargetFS
+	}
+	if err := opts.checkSourceFS(); err != nil {
+		return Diff{}, err
+	}
+	sources := opts.sources()
+	if _, err := statSources(rep.src, sources); err != nil {
+		return Diff{}, err
+	}
+	if err := checkTarget(rep.dst, opts.Target); err != nil {
+		return Diff{}, err
+	}
+	sourceEntries := map[string]fileEntry{}
+	for _, source := range sources {
+		collectEntries(runCtx, rep.src, source, rep.rootOf(source), opts.Follow, opts.Threads, rep, sourceEntries)
+	}
+	if opts.Normalize != NormalizeNone {
+		// match the source against the names it was given in the target
+		normalized := make(map[string]fileEntry, len(sourceEntries))
+		for rel, entry := range sourceEntries {
+			normalized[opts.normalize(rel)] = entry
+		}
+		sourceEntries = normalized
+	}
+	targetEntries := map[string]fileEntry{}
+	if _, err := os.Stat(opts.Target); err == nil {
+		collectEntries(runCtx, osSource{}, opts.Target, opts.Target, opts.Follow, opts.Threads, rep, targetEntries)
+	}
+
+	var diff Diff
+	var mu sync.Mutex
+	pool := NewThreadPool(int(opts.Threads))
+	for rel, src := range sourceEntries {
+		dst, ok := targetEntries[rel]
+		if !ok {
+			diff.OnlyInSource = append(diff.OnlyInSource, rel)
+			continue
+		}
+		rel, src := rel, src
+		err := pool.Submit(runCtx, func() {
+			same, err := sameEntry(rep.src, src, dst, checksum)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err != nil:
+				rep.fail("comparing", src.path, err)
+			case same:
+				diff.Same++
+			default:
+				diff.Different = append(diff.Different, rel)
+			}
+		}, func(p *PanicError) {
+			rep.fail("comparing", src.path, p)
+		})
+		if err != nil {
+			break
+		}
+	}
+	pool.Stop()
+	for rel := range targetEntries {
+		if _, ok := sourceEntries[rel]; !ok {
+			diff.OnlyInTarget = append(diff.OnlyInTarget, rel)
+		}
+	}
+
+	sort.Strings(diff.OnlyInSource)
+	sort.Strings(diff.OnlyInTarget)
+	sort.Strings(diff.Different)
+	diff.Errors = rep.errors
+	return diff, ctx.Err()
+}
+
+// collectEntries scans path in fsys with getFilesAndDir and adds what it
+// finds to entries, keyed by path relative to root.
+func collectEntries(ctx context.Context, fsys sourceFS, path, root string, follow bool, threads uint, rep *report, entries map[string]fileEntry) {
+	_, _, folders, files := getFilesAndDir(ctx, fsys, path, follow, threads, rep)
+	for _, entry := range append(folders, files...) {
+		rel, _ := filepath.Rel(root, entry.path)
+		if rel != "." {
+			entries[rel] = entry
+		}
+	}
+}
+
+// sameEntry reports whether src, read through fsys, and dst are of the same
+// kind and, for files and links, hold the same size and content or point at
+// the same target.
+func sameEntry(fsys sourceFS, src, dst fileEntry, checksum bool) (bool, error) {
+	if src.info.Mode().Type() != dst.info.Mode().Type() {
+		return false, nil
+	}
+	switch {
+	case src.info.IsDir():
+		return true, nil
+	case src.info.Mode()&os.ModeSymlink != 0:
+		srcTarget, err := fsys.ReadLink(src.path)
+		if err != nil {
+			return false, err
+		}
+		dstTarget, err := os.Readlink(dst.path)
+		if err != nil {
+			return false, err
+		}
+		return srcTarget == dstTarget, nil
+	case src.info.Size() != dst.info.Size():
+		return false, nil
+	case !checksum || !src.info.Mode().IsRegular():
+		return true, nil
+	}
+
+	srcSum, err := hashSource(fsys, src.path)
+	if err != nil {
+		return false, err
+	}
+	dstSum, err := hashFile(dst.path)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(srcSum, dstSum), nil
+}
@@ -0,0 +1,147 @@
+package gocp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"io"
+	"io/fs"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression selects how the copied files are encoded in the target.
+type Compression int
+
+const (
+	// CompressNone copies the files as they are.
+	CompressNone Compression = iota
+	// CompressGzip gzips every file and adds ".gz" to its name.
+	CompressGzip
+	// CompressZstd compresses every file with Zstandard and adds ".zst" to
+	// its name.
+	CompressZstd
+)
+
+// compressionExts maps each compression to the extension of its files.
+var compressionExts = map[Compression]string{
+	CompressGzip: ".gz",
+	CompressZstd: ".zst",
+}
+
+// compressionOf returns the compression of path told by its extension.
+func compressionOf(path string) Compression {
+	for c, ext := range compressionExts {
+		if strings.HasSuffix(path, ext) {
+			return c
+		}
+	}
+	return CompressNone
+}
+
+// codecs returns how the source path of a regular file is encoded and
+// decoded on its way to the target.
+func (opts *Options) codecs(src string) (encode, decode Compression) {
+	if opts.Decompress {
+		return CompressNone, compressionOf(src)
+	}
+	return opts.Compress, CompressNone
+}
+
+// coded reports whether the source file src changes its encoding on its way
+// to the target.
+func (opts *Options) coded(src string) bool {
+	encode, decode := opts.codecs(src)
+	return encode != CompressNone || decode != CompressNone
+}
+
+// targetName returns the name dst of the source file src takes in the
+// target once compressed or decompressed.
+func (opts *Options) targetName(src, dst string) string {
+	encode, decode := opts.codecs(src)
+	if encode != CompressNone {
+		return dst + compressionExts[encode]
+	}
+	return strings.TrimSuffix(dst, compressionExts[decode])
+}
+
+// sourceNames returns the names a target file of a compressed or
+// decompressed run may have had in the source.
+func (opts *Options) sourceNames(name string) []string {
+	if opts.Decompress {
+		names := []string{name}
+		for _, ext := range compressionExts {
+			names = append(names, name+ext)
+		}
+		return names
+	}
+	if ext := compressionExts[opts.Compress]; ext != "" && strings.HasSuffix(name, ext) {
+		return []string{name, strings.TrimSuffix(name, ext)}
+	}
+	return []string{name}
+}
+
+// nopWriteCloser is the encoder of CompressNone.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// newEncoder returns a writer compressing into w, which must be closed to
+// flush it.
+func newEncoder(c Compression, w io.Writer) (io.WriteCloser, error) {
+	switch c {
+	case CompressGzip:
+		return gzip.NewWriter(w), nil
+	case CompressZstd:
+		// the workers already keep every core busy
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	}
+	return nopWriteCloser{w}, nil
+}
+
+// newDecoder returns a reader decompressing r, to be closed once done.
+func newDecoder(c Compression, r io.Reader) (io.ReadCloser, error) {
+	switch c {
+	case CompressGzip:
+		return gzip.NewReader(r)
+	case CompressZstd:
+		dec, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	}
+	return io.NopCloser(r), nil
+}
+
+// verifyEncoded re-reads path in fsys, decoding it from c, and compares the
+// SHA-256 of its content against sum.
+func verifyEncoded(fsys fs.FS, path string, c Compression, sum []byte) error {
+	if c == CompressNone {
+		return verifyFile(fsys, path, sum)
+	}
+	file, err := fsys.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	dec, err := newDecoder(c, file)
+	if err != nil {
+		return err
+	}
+	defer dec.Close()
+
+	fileHash := sha256.New()
+	if _, err := io.Copy(fileHash, dec); err != nil {
+		return err
+	}
+	if !bytes.Equal(fileHash.Sum(nil), sum) {
+		return errChecksumMismatch
+	}
+	return nil
+}
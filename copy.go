@@ -0,0 +1,633 @@
+package gocp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// DefaultBufferSize is the copy buffer size used when Options.BufferSize is 0.
+const DefaultBufferSize = 1 << 20
+
+// bufferPool holds copy buffers so workers reuse them across files.
+var bufferPool sync.Pool
+
+// getBuffer returns a buffer of size bytes, reusing a pooled one if possible.
+func getBuffer(size int) *[]byte {
+	if buf, ok := bufferPool.Get().(*[]byte); ok && len(*buf) == size {
+		return buf
+	}
+	buf := make([]byte, size)
+	return &buf
+}
+
+// copyEntry copies a single scanned file, link or not, to destFile and
+// records the outcome.
+func copyEntry(ctx context.Context, opts *Options, file fileEntry, destFile string, rep *report) {
+	if ctx.Err() != nil {
+		return
+	}
+	start := time.Now()
+
+	isLink := file.info.Mode()&os.ModeSymlink != 0
+	sized := true
+	if file.info.Mode().IsRegular() {
+		destFile = opts.targetName(file.path, destFile)
+		sized = !opts.coded(file.path)
+		if rep.manifest != nil && rep.manifest.completed(destFile, file.info) {
+			skipEntry(opts, file, destFile, start, rep)
+			return
+		}
+	}
+	if opts.Update && !isLink && isUpToDate(rep.dst, destFile, file.info, sized) {
+		skipEntry(opts, file, destFile, start, rep)
+		return
+	}
+
+	if opts.Conflict != ConflictOverwrite {
+		if _, err := rep.dst.Lstat(destFile); err == nil {
+			if opts.Conflict == ConflictSkip {
+				skipEntry(opts, file, destFile, start, rep)
+				return
+			}
+			destFile = freeName(rep.dst, destFile)
+		}
+	}
+
+	if opts.DryRun {
+		opts.Logger.Info("Would copy", "source", file.path, "target", destFile)
+		if opts.Move {
+			opts.Logger.Info("Would delete", "path", file.path)
+		}
+		rep.copied.Add(1)
+		if !isLink {
+			rep.bytes.Add(uint64(file.info.Size()))
+			opts.Progress.Add(file.info.Size())
+		}
+		opts.Progress.Increment()
+		return
+	}
+
+	if opts.OnFileStart != nil {
+		opts.OnFileStart(file.path, file.info.Size())
+	}
+
+	// report the bytes of this file separately if the progress wants them
+	if fp, ok := opts.Progress.(FileProgress); ok && !isLink {
+		tracker := fp.StartFile(file.path, file.info.Size())
+		defer tracker.Done()
+		withFile := *opts
+		withFile.Progress = trackedProgress{opts.Progress, tracker}
+		opts = &withFile
+	}
+
+	// link to the copy of an inode or a content seen before, falling back
+	// to a copy
+	var target *linkTarget
+	linked, deduped := false, false
+	var key any
+	if sum, ok := rep.dupes[file.path]; ok {
+		key, deduped = sum, true
+	} else if inode, ok := inodeOf(file.info); ok && opts.Hardlinks && file.info.Mode().IsRegular() {
+		key = inode
+	}
+	if key != nil {
+		var first bool
+		target, first = rep.hardlinks.claim(key)
+		if first {
+			// the paths waiting to link to the copy mustn't hang on a panic
+			defer func() {
+				if v := recover(); v != nil {
+					if target != nil {
+						target.finish(destFile, &PanicError{Value: v, Stack: debug.Stack()})
+					}
+					panic(v)
+				}
+			}()
+		} else {
+			linked = target.link(ctx, destFile) == nil
+			target = nil
+		}
+	}
+
+	var err error
+	var sum []byte
+	if !linked {
+		sum, err = copyWithTimeout(ctx, opts.FileTimeout, func(ctx context.Context) ([]byte, error) {
+			var sum []byte
+			err := copyWithRetries(ctx, opts, rep, func(attempt *Options) error {
+				if isLink {
+					return copySymlink(file.path, destFile, file.info, attempt, rep)
+				}
+				if isSpecial(file.info.Mode()) {
+					return copySpecial(file.path, destFile, file.info, attempt, rep)
+				}
+				var err error
+				sum, err = copyFile(ctx, file.path, destFile, file.info, attempt, rep)
+				return err
+			})
+			return sum, err
+		})
+	}
+	if target != nil {
+		target.finish(destFile, err)
+		target = nil
+	}
+	if ctx.Err() != nil {
+		// the run was canceled, not a failure of this file
+		if opts.OnFileDone != nil {
+			opts.OnFileDone(file.path, 0, ctx.Err())
+		}
+		return
+	}
+	if errors.Is(err, errChecksumMismatch) {
+		rep.mismatch(file.path)
+	}
+	if err != nil {
+		rep.fail("copying file", file.path, err)
+		if !isLink {
+			rep.failedBytes.Add(uint64(file.info.Size()))
+		}
+		record(opts, file, destFile, start, ResultFailed, err, nil)
+		if opts.OnFileDone != nil {
+			opts.OnFileDone(file.path, 0, err)
+		}
+	} else {
+		rep.copied.Add(1)
+		result := ResultCopied
+		if linked {
+			if deduped {
+				rep.deduped.Add(1)
+				rep.dedupedBytes.Add(uint64(file.info.Size()))
+			} else {
+				rep.linked.Add(1)
+			}
+			opts.Progress.Add(file.info.Size())
+			result = ResultLinked
+			if opts.Checksums && file.info.Mode().IsRegular() {
+				// the content was written by another path's copy
+				if sum, err = hashFile(destFile); err != nil {
+					rep.fail("hashing linked file", destFile, err)
+				}
+			}
+		} else if !isLink {
+			rep.bytes.Add(uint64(file.info.Size()))
+			if opts.Verify && file.info.Mode().IsRegular() {
+				result = ResultVerified
+			}
+		}
+		record(opts, file, destFile, start, result, nil, sum)
+		if opts.OnFileDone != nil {
+			var bytes int64
+			if !isLink {
+				bytes = file.info.Size()
+			}
+			opts.OnFileDone(file.path, bytes, nil)
+		}
+		if rep.manifest != nil && file.info.Mode().IsRegular() {
+			if err := rep.manifest.add(destFile); err != nil {
+				rep.fail("recording in the resume manifest", destFile, err)
+			}
+		}
+		if opts.Verbose {
+			opts.Logger.Debug("Copied", "source", file.path, "target", destFile, "size", humanize.IBytes(uint64(file.info.Size())))
+		}
+		if opts.Move {
+			if err := os.Remove(file.path); err != nil {
+				rep.fail("deleting source file", file.path, err)
+			}
+		}
+	}
+	opts.Progress.Increment()
+}
+
+// skipEntry records file, bound for destFile and looked at since start, as
+// skipped.
+func skipEntry(opts *Options, file fileEntry, destFile string, start time.Time, rep *report) {
+	record(opts, file, destFile, start, ResultSkipped, nil, nil)
+	rep.skipped.Add(1)
+	rep.skippedBytes.Add(uint64(file.info.Size()))
+	opts.Progress.Add(file.info.Size())
+	opts.Progress.Increment()
+}
+
+// isUpToDate reports whether dst was modified no earlier than the source and,
+// if sized is set, holds the same size as well. Compressed or decompressed
+// files keep no size in common with their source.
+func isUpToDate(fsys TargetFS, dst string, info os.FileInfo, sized bool) bool {
+	dstInfo, err := fsys.Stat(dst)
+	if err != nil {
+		return false
+	}
+	modTime := info.ModTime()
+	if dstInfo.ModTime().Nanosecond() == 0 {
+		// the target keeps whole seconds, as over SFTP
+		modTime = modTime.Truncate(time.Second)
+	}
+	return (!sized || dstInfo.Size() == info.Size()) && !dstInfo.ModTime().Before(modTime)
+}
+
+// freeName returns the first of "name (1).ext", "name (2).ext" and so on
+// that doesn't exist yet.
+func freeName(fsys TargetFS, path string) string {
+	for i := 1; ; i++ {
+		candidate := numberedName(path, i)
+		if _, err := fsys.Lstat(candidate); errors.Is(err, fs.ErrNotExist) {
+			return candidate
+		}
+	}
+}
+
+// numberedName returns path with " (n)" added before its extension.
+func numberedName(path string, n int) string {
+	ext := filepath.Ext(path)
+	if ext == filepath.Base(path) {
+		// a dot file such as ".profile" has no extension
+		ext = ""
+	}
+	return fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(path, ext), n, ext)
+}
+
+// tempSuffix ends the temporary name of a target file while it is being
+// copied.
+const tempSuffix = ".gocp-tmp"
+
+// tempCount numbers the temporary names of the process.
+var tempCount atomic.Uint64
+
+// tempPath returns a temporary name for dst of its own, so that two writers
+// of the same target, such as a retry and the copy FileTimeout abandoned,
+// never share one.
+func tempPath(dst string) string {
+	return fmt.Sprintf("%s.%d-%d%s", dst, os.Getpid(), tempCount.Add(1), tempSuffix)
+}
+
+// copyFile copies src to dst through a temporary file that is renamed into
+// place once complete, so dst never holds a partially written file. With
+// Checksums, it returns the SHA-256 of what dst holds.
+func copyFile(ctx context.Context, src, dst string, info os.FileInfo, opts *Options, rep *report) (sum []byte, err error) {
+	if opts.TargetFS == nil {
+		dst = longPath(dst)
+	}
+	if opts.SourceFS == nil {
+		src = longPath(src)
+	}
+	perm, setPerm := opts.filePerm(info.Mode())
+
+	if err := rep.files.acquire(ctx, filesPerCopy); err != nil {
+		return nil, err
+	}
+	defer rep.files.release(filesPerCopy)
+
+	// a DirectTargetFS is written in place, its files showing once complete,
+	// and so is a file Delta updates
+	tmp := tempPath(dst)
+	_, inPlace := rep.dst.(DirectTargetFS)
+	delta := opts.deltaTarget(src, dst, info.Size())
+	if inPlace || delta {
+		tmp = dst
+	}
+	defer func() {
+		if err != nil && tmp != dst {
+			rep.dst.Remove(tmp)
+		}
+	}()
+
+	// a clone bypasses the buffer, so in auto mode it is only tried when
+	// nothing needs to see the data as it is copied. Neither a clone nor a
+	// split copy can change the encoding of the data, and both need the
+	// source and the target on the OS file system.
+	coded := opts.coded(src)
+	direct := !coded && opts.SourceFS == nil && opts.TargetFS == nil
+	cloned := false
+	if direct && !delta && (opts.Reflink == ReflinkAlways || (opts.Reflink == ReflinkAuto && !opts.Verify && rep.limiter == nil)) {
+		err := cloneFile(ctx, src, tmp, perm, opts)
+		if err != nil && (opts.Reflink == ReflinkAlways || !errors.Is(err, errCloneUnsupported)) {
+			return nil, fmt.Errorf("Failed to clone file: %w", err)
+		}
+		cloned = err == nil
+	}
+
+	if delta {
+		if sum, err = writeFileDelta(ctx, src, dst, info, opts, rep); err != nil {
+			return nil, err
+		}
+	} else if !cloned {
+		write := writeFile
+		switch {
+		case direct && opts.Sparse:
+			write = writeFileSparse
+		case direct && opts.SplitThreshold > 0 && uint64(info.Size()) >= opts.SplitThreshold && opts.Threads > 1:
+			write = writeFileSplit
+		}
+		if sum, err = write(ctx, src, tmp, info, perm, opts, rep); err != nil {
+			return nil, err
+		}
+	} else {
+		if opts.Fsync {
+			if err := syncFile(tmp); err != nil {
+				return nil, fmt.Errorf("Failed to sync target file: %w", err)
+			}
+		}
+		if opts.Verify {
+			if sum, err = hashFile(src); err != nil {
+				return nil, fmt.Errorf("Cannot read source file: %w", err)
+			}
+			if err := verifyFile(rep.dst, tmp, sum); err != nil {
+				return nil, fmt.Errorf("Failed to verify target file: %w", err)
+			}
+		} else if opts.Checksums {
+			if sum, err = hashFile(tmp); err != nil {
+				return nil, fmt.Errorf("Cannot read target file: %w", err)
+			}
+		}
+	}
+
+	// change the owner before the mode, since a chown may clear the
+	// setuid and setgid bits.
+	if opts.Owner {
+		if err := rep.preserveOwner(opts.Logger, tmp, info, false); err != nil {
+			return nil, fmt.Errorf("Failed to set target file owner: %w", err)
+		}
+	}
+
+	// the temporary file is created with perm, but that only applies to
+	// new files and is subject to umask, so set it explicitly as well.
+	if setPerm {
+		if err := rep.dst.Chmod(tmp, perm); err != nil {
+			return nil, fmt.Errorf("Failed to set target file mode: %w", err)
+		}
+	}
+
+	if opts.Xattrs {
+		if err := rep.preserveXattrs(opts.Logger, src, tmp, false); err != nil {
+			return nil, fmt.Errorf("Failed to set target file extended attributes: %w", err)
+		}
+	}
+
+	// after the mode, which would rewrite the mask of the ACL
+	if opts.ACL {
+		rep.preserveACL(opts.Logger, src, tmp)
+	}
+
+	if opts.Preserve {
+		// the access time isn't portable across platforms, so use the
+		// modification time for both.
+		if err := rep.dst.Chtimes(tmp, info.ModTime(), info.ModTime()); err != nil {
+			return nil, fmt.Errorf("Failed to set target file times: %w", err)
+		}
+		// after the modification time, which macOS may pull it back to
+		if opts.TargetFS == nil {
+			if err := preserveBirthtime(tmp, info); err != nil {
+				return nil, fmt.Errorf("Failed to set target file creation time: %w", err)
+			}
+		}
+	}
+
+	// a copy abandoned by FileTimeout must not land after all
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if tmp != dst {
+		if err := rep.dst.Rename(tmp, dst); err != nil {
+			return nil, fmt.Errorf("Failed to move target file into place: %w", err)
+		}
+	}
+
+	if opts.Fsync && opts.TargetFS == nil {
+		if err := syncDir(filepath.Dir(dst)); err != nil {
+			return nil, fmt.Errorf("Failed to sync target folder: %w", err)
+		}
+	}
+
+	if !opts.Checksums {
+		sum = nil
+	}
+	return sum, nil
+}
+
+// syncFile flushes the content of path to disk.
+func syncFile(path string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
+
+// writeFile copies the content of src to the new file tmp through a pooled
+// buffer, compressing or decompressing it and verifying it if requested.
+// The progress counts the bytes read from src. With Checksums, it returns
+// the SHA-256 of what tmp holds.
+func writeFile(ctx context.Context, src, tmp string, info os.FileInfo, perm os.FileMode, opts *Options, rep *report) ([]byte, error) {
+	encode, decode := opts.codecs(src)
+
+	// open the source file
+	srcFile, err := rep.src.Open(src)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot open source file: %w", err)
+	}
+	defer srcFile.Close()
+
+	// create the temporary file with the final mode so it is never more
+	// permissive than the source, even while it is being written.
+	var dstFile io.WriteCloser
+	if target, ok := rep.dst.(DirectTargetFS); ok {
+		dstFile, err = target.CreateDirect(tmp, perm)
+	} else {
+		dstFile, err = rep.dst.Create(tmp, perm)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create target file: %w", err)
+	}
+	defer dstFile.Close()
+
+	if file, ok := dstFile.(*os.File); ok && opts.Preallocate && info.Size() > 0 && !opts.coded(src) {
+		preallocate(file, info.Size())
+	}
+
+	var reader io.Reader = progressReader{contextReader{ctx, srcFile}, opts.Progress}
+	if decode != CompressNone {
+		dec, err := newDecoder(decode, reader)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot decompress source file: %w", err)
+		}
+		defer dec.Close()
+		reader = dec
+	}
+
+	// hash the source as it is copied, so it only needs to be read once.
+	// It is what the target holds unless it is compressed, in which case
+	// the target is hashed as it is written.
+	var srcHash, dstHash hash.Hash
+	if opts.Verify || (opts.Checksums && encode == CompressNone) {
+		srcHash = sha256.New()
+		reader = io.TeeReader(reader, srcHash)
+	}
+
+	// copy file through a pooled buffer. The writer is wrapped so that
+	// io.CopyBuffer can't bypass the buffer through os.File.ReadFrom.
+	var writer io.Writer = struct{ io.Writer }{dstFile}
+	if rep.limiter != nil {
+		writer = limitedWriter{ctx, dstFile, rep.limiter}
+	}
+	if opts.Checksums && encode != CompressNone {
+		dstHash = sha256.New()
+		writer = io.MultiWriter(writer, dstHash)
+	}
+	enc, err := newEncoder(encode, writer)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot compress target file: %w", err)
+	}
+	if opts.ReadAhead > 0 {
+		_, err = copyReadAhead(enc, reader, opts.BufferSize, opts.ReadAhead)
+	} else {
+		buf := getBuffer(opts.BufferSize)
+		defer bufferPool.Put(buf)
+		_, err = io.CopyBuffer(struct{ io.Writer }{enc}, reader, *buf)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to copy file: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("Failed to compress file: %w", err)
+	}
+
+	// a TargetFS file is flushed if it can be
+	if file, ok := dstFile.(interface{ Sync() error }); ok && opts.Fsync {
+		if err := file.Sync(); err != nil {
+			return nil, fmt.Errorf("Failed to sync target file: %w", err)
+		}
+	}
+
+	if file, ok := dstFile.(DirectFile); ok {
+		err = file.Commit()
+	} else {
+		err = dstFile.Close()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to close target file: %w", err)
+	}
+
+	if opts.Verify {
+		if err := verifyEncoded(rep.dst, tmp, encode, srcHash.Sum(nil)); err != nil {
+			return nil, fmt.Errorf("Failed to verify target file: %w", err)
+		}
+	}
+
+	switch {
+	case dstHash != nil:
+		return dstHash.Sum(nil), nil
+	case opts.Checksums:
+		return srcHash.Sum(nil), nil
+	}
+	return nil, nil
+}
+
+// contextReader fails reads once ctx is done, so that canceling a run stops
+// copies in the middle of a file.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// trackedProgress reports the bytes of a file to its FileTracker as well.
+type trackedProgress struct {
+	Progress
+	file FileTracker
+}
+
+func (p trackedProgress) Add(n int64) {
+	p.file.Add(n)
+	p.Progress.Add(n)
+}
+
+// progressReader reports the bytes read through it to a Progress.
+type progressReader struct {
+	r        io.Reader
+	progress Progress
+}
+
+func (r progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.progress.Add(int64(n))
+	}
+	return n, err
+}
+
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+// verifyFile re-reads path in fsys and compares its SHA-256 against sum.
+func verifyFile(fsys fs.FS, path string, sum []byte) error {
+	dstSum, err := hashSource(fsys, path)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(dstSum, sum) {
+		return errChecksumMismatch
+	}
+
+	return nil
+}
+
+// hashFile returns the SHA-256 of the content of path.
+func hashFile(path string) ([]byte, error) {
+	return hashSource(osSource{}, path)
+}
+
+// copySymlink recreates the symbolic link src at dst, pointing at the same
+// target even if that target doesn't exist.
+func copySymlink(src, dst string, info os.FileInfo, opts *Options, rep *report) error {
+	target, err := rep.src.ReadLink(src)
+	if err != nil {
+		return fmt.Errorf("Cannot read source link: %w", err)
+	}
+
+	// create the link under a temporary name and rename it into place, so
+	// an existing entry at dst is replaced the same way copyFile does.
+	tmp := tempPath(dst)
+	if err := rep.dst.Symlink(target, tmp); err != nil {
+		return fmt.Errorf("Failed to create target link: %w", err)
+	}
+	if opts.Owner {
+		if err := rep.preserveOwner(opts.Logger, tmp, info, true); err != nil {
+			os.Remove(tmp)
+			return fmt.Errorf("Failed to set target link owner: %w", err)
+		}
+	}
+	if opts.Xattrs {
+		if err := rep.preserveXattrs(opts.Logger, src, tmp, true); err != nil {
+			os.Remove(tmp)
+			return fmt.Errorf("Failed to set target link extended attributes: %w", err)
+		}
+	}
+	if err := rep.dst.Rename(tmp, dst); err != nil {
+		rep.dst.Remove(tmp)
+		return fmt.Errorf("Failed to move target link into place: %w", err)
+	}
+
+	return nil
+}
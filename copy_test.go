@@ -0,0 +1,167 @@
+package gocp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// panicProgress panics the first time data is added, in the middle of the
+// copy of a file.
+type panicProgress struct {
+	once sync.Once
+}
+
+func (p *panicProgress) Start(files, bytes uint64) {}
+func (p *panicProgress) Increment()                {}
+func (p *panicProgress) Finish()                   {}
+
+func (p *panicProgress) Add(n int64) {
+	p.once.Do(func() { panic("copy panicked") })
+}
+
+func TestPanicReleasesLinks(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source")
+	target := filepath.Join(dir, "target")
+	writeTree(t, source, map[string]string{"a": "same", "b": "same"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	result, err := Copy(ctx, Options{
+		Source:   source,
+		Target:   target,
+		Threads:  2,
+		Dedup:    true,
+		Progress: &panicProgress{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctx.Err() != nil {
+		t.Fatal("the run hung on the panicked copy")
+	}
+	if len(result.Errors) != 1 {
+		t.Fatalf("got %d errors, want the panic alone: %v", len(result.Errors), result.Errors)
+	}
+	var panicErr *PanicError
+	if !errors.As(result.Errors[0], &panicErr) {
+		t.Errorf("got %v, want a PanicError", result.Errors[0])
+	}
+	if result.Copied != 1 {
+		t.Errorf("copied %d files, want the one that didn't panic", result.Copied)
+	}
+}
+
+// latency is the round trip BenchmarkCopyFile gives every read and write,
+// as a network share would.
+const latency = 200 * time.Microsecond
+
+// slowFS is a file system whose files wait out latency on every read.
+type slowFS struct {
+	fs.FS
+}
+
+func (s slowFS) Open(name string) (fs.File, error) {
+	file, err := s.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return slowFile{file}, nil
+}
+
+type slowFile struct {
+	fs.File
+}
+
+func (f slowFile) Read(p []byte) (int, error) {
+	time.Sleep(latency)
+	return f.File.Read(p)
+}
+
+// slowTarget is the OS file system, waiting out latency on every write.
+type slowTarget struct {
+	osTarget
+}
+
+func (slowTarget) Create(name string, perm fs.FileMode) (io.WriteCloser, error) {
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return nil, err
+	}
+	return slowWriter{file}, nil
+}
+
+type slowWriter struct {
+	*os.File
+}
+
+func (w slowWriter) Write(p []byte) (int, error) {
+	time.Sleep(latency)
+	return w.File.Write(p)
+}
+
+// BenchmarkCopyFile copies a file between a source and a target of high
+// latency with a plain io.Copy, through the pooled buffer, and reading
+// ahead through the larger buffers of -nfs-mode.
+func BenchmarkCopyFile(b *testing.B) {
+	const size = 16 << 20
+	dir := b.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "file"), bytes.Repeat([]byte{1}, size), 0o644); err != nil {
+		b.Fatal(err)
+	}
+	source := slowFS{os.DirFS(dir)}
+	target := filepath.Join(dir, "copy")
+
+	b.Run("io.Copy", func(b *testing.B) {
+		b.SetBytes(size)
+		for i := 0; i < b.N; i++ {
+			src, err := source.Open("file")
+			if err != nil {
+				b.Fatal(err)
+			}
+			dst, err := slowTarget{}.Create(target, 0o644)
+			if err != nil {
+				b.Fatal(err)
+			}
+			// keep io.Copy from handing the copy to the kernel
+			_, err = io.Copy(struct{ io.Writer }{dst}, struct{ io.Reader }{src})
+			src.Close()
+			if closeErr := dst.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	for _, variant := range []struct {
+		name          string
+		buffer, ahead int
+	}{
+		{"buffered", 0, 0},
+		{"read-ahead", 8 << 20, 4},
+	} {
+		b.Run(variant.name, func(b *testing.B) {
+			b.SetBytes(size)
+			opts := Options{
+				SourceFS:   source,
+				Source:     "file",
+				Target:     target,
+				TargetFS:   slowTarget{},
+				BufferSize: variant.buffer,
+				ReadAhead:  variant.ahead,
+			}
+			for i := 0; i < b.N; i++ {
+				copyTree(b, opts)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package gocp
+
+import (
+	"context"
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+// findDuplicates returns the SHA-256 of the regular files sharing their
+// content with another one of files. Only files of the same size are hashed,
+// with a worker per thread. Files that can't be read are copied as usual
+// and fail then.
+func findDuplicates(ctx context.Context, opts *Options, files []fileEntry, rep *report) map[string][sha256.Size]byte {
+	start := time.Now()
+	bySize := map[int64][]fileEntry{}
+	for _, file := range files {
+		if file.info.Mode().IsRegular() && file.info.Size() > 0 {
+			bySize[file.info.Size()] = append(bySize[file.info.Size()], file)
+		}
+	}
+
+	var mu sync.Mutex
+	sums := map[string][sha256.Size]byte{}
+	counts := map[[sha256.Size]byte]int{}
+	hashed := 0
+	pool := NewThreadPool(int(opts.Threads))
+	for _, group := range bySize {
+		if len(group) < 2 {
+			continue
+		}
+		for _, file := range group {
+			file := file
+			hashed++
+			err := pool.Submit(ctx, func() {
+				sum, err := hashSource(rep.src, file.path)
+				if err != nil {
+					return
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				key := [sha256.Size]byte(sum)
+				sums[file.path] = key
+				counts[key]++
+			}, nil)
+			if err != nil {
+				break
+			}
+		}
+	}
+	pool.Stop()
+
+	for path, sum := range sums {
+		if counts[sum] < 2 {
+			delete(sums, path)
+		}
+	}
+	opts.Logger.Info("Looked for duplicate files", "hashed", hashed, "duplicates", len(sums), "elapsed", time.Since(start))
+	return sums
+}
@@ -0,0 +1,131 @@
+package gocp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+)
+
+// deltaBlockSize is the size of the blocks Delta compares and rewrites.
+const deltaBlockSize = 64 << 10
+
+// deltaTarget reports whether Delta updates dst in place from src of size
+// bytes, which it does for a regular file of the same size on the OS file
+// system. A file with other links, such as those of Dedup or Hardlinks, is
+// left to be replaced, since writing it would change them all, and so is a
+// file that can't be written, such as a read-only one an earlier Preserve
+// run left.
+func (opts *Options) deltaTarget(src, dst string, size int64) bool {
+	if !opts.Delta || size == 0 || opts.SourceFS != nil || opts.TargetFS != nil || opts.coded(src) {
+		return false
+	}
+	info, err := os.Lstat(dst)
+	if err != nil || !info.Mode().IsRegular() || info.Size() != size {
+		return false
+	}
+	if _, linked := inodeOf(info); linked {
+		return false
+	}
+	file, err := os.OpenFile(dst, os.O_RDWR, 0)
+	if err != nil {
+		return false
+	}
+	file.Close()
+	return true
+}
+
+// writeFileDelta updates dst, of the size of src, in place. Both are read a
+// buffer at a time, and only the blocks of dst that differ are written over
+// with WriteAt. With Verify or Checksums, it returns the SHA-256 of src,
+// hashed as it is read.
+func writeFileDelta(ctx context.Context, src, dst string, info os.FileInfo, opts *Options, rep *report) ([]byte, error) {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot open source file: %w", err)
+	}
+	defer srcFile.Close()
+
+	dstFile, err := os.OpenFile(dst, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open target file: %w", err)
+	}
+	defer dstFile.Close()
+
+	var reader io.Reader = progressReader{contextReader{ctx, io.LimitReader(srcFile, info.Size())}, opts.Progress}
+	var srcHash hash.Hash
+	if opts.Verify || opts.Checksums {
+		srcHash = sha256.New()
+		reader = io.TeeReader(reader, srcHash)
+	}
+
+	srcBuf := getBuffer(opts.BufferSize)
+	defer bufferPool.Put(srcBuf)
+	dstBuf := getBuffer(opts.BufferSize)
+	defer bufferPool.Put(dstBuf)
+
+	var offset, written int64
+	for offset < info.Size() {
+		n, err := io.ReadFull(reader, *srcBuf)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read source file: %w", err)
+		}
+		if n == 0 {
+			break
+		}
+		if _, err := io.ReadFull(dstFile, (*dstBuf)[:n]); err != nil {
+			return nil, fmt.Errorf("Failed to read target file: %w", err)
+		}
+
+		for start := 0; start < n; start += deltaBlockSize {
+			end := min(start+deltaBlockSize, n)
+			block := (*srcBuf)[start:end]
+			if bytes.Equal(block, (*dstBuf)[start:end]) {
+				continue
+			}
+			if rep.limiter != nil {
+				if err := rep.limiter.wait(ctx, len(block)); err != nil {
+					return nil, err
+				}
+			}
+			if _, err := dstFile.WriteAt(block, offset+int64(start)); err != nil {
+				return nil, fmt.Errorf("Failed to copy file: %w", err)
+			}
+			written += int64(len(block))
+		}
+		offset += int64(n)
+	}
+	if offset < info.Size() {
+		// the source shrank since it was scanned
+		if err := dstFile.Truncate(offset); err != nil {
+			return nil, fmt.Errorf("Failed to size target file: %w", err)
+		}
+	}
+	opts.Logger.Debug("Updated changed blocks", "path", dst, "written", written, "size", info.Size())
+
+	if opts.Fsync {
+		if err := dstFile.Sync(); err != nil {
+			return nil, fmt.Errorf("Failed to sync target file: %w", err)
+		}
+	}
+	if err := dstFile.Close(); err != nil {
+		return nil, fmt.Errorf("Failed to close target file: %w", err)
+	}
+
+	if srcHash == nil {
+		return nil, nil
+	}
+	sum := srcHash.Sum(nil)
+	if opts.Verify {
+		if err := verifyFile(osSource{}, dst, sum); err != nil {
+			return nil, fmt.Errorf("Failed to verify target file: %w", err)
+		}
+	}
+	return sum, nil
+}
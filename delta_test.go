@@ -0,0 +1,57 @@
+package gocp
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDeltaKeepsOtherLinks(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source")
+	target := filepath.Join(dir, "target")
+	old := bytes.Repeat([]byte("old "), 64<<10)
+	changed := append([]byte("new "), old[4:]...)
+	writeTree(t, source, map[string]string{"file": string(changed)})
+	writeTree(t, target, map[string]string{"file": string(old)})
+	link := filepath.Join(dir, "link")
+	if err := os.Link(filepath.Join(target, "file"), link); err != nil {
+		t.Skip("hard links are not supported:", err)
+	}
+	info, err := os.Lstat(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := inodeOf(info); !ok {
+		t.Skip("the links of a file are not told apart on this platform")
+	}
+
+	copyTree(t, Options{Source: source, Target: target, Delta: true})
+	got, _ := os.ReadFile(filepath.Join(target, "file"))
+	if !bytes.Equal(got, changed) {
+		t.Error("the target wasn't updated")
+	}
+	if got, _ := os.ReadFile(link); !bytes.Equal(got, old) {
+		t.Error("the other link of the target was changed")
+	}
+}
+
+func TestDeltaReadOnlyTarget(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source")
+	target := filepath.Join(dir, "target")
+	old := bytes.Repeat([]byte("old "), 64<<10)
+	changed := append([]byte("new "), old[4:]...)
+	writeTree(t, source, map[string]string{"file": string(changed)})
+	writeTree(t, target, map[string]string{"file": string(old)})
+	if err := os.Chmod(filepath.Join(target, "file"), 0o444); err != nil {
+		t.Fatal(err)
+	}
+
+	copyTree(t, Options{Source: source, Target: target, Delta: true})
+	got, _ := os.ReadFile(filepath.Join(target, "file"))
+	if !bytes.Equal(got, changed) {
+		t.Error("the read-only target wasn't replaced")
+	}
+}
@@ -0,0 +1,48 @@
+package gocp
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrDrained is returned by Copy with the partial Result when Options.Drain
+// ended the run.
+var ErrDrained = errors.New("Copy was stopped before the end.")
+
+// drained reports whether Drain was closed.
+func (opts *Options) drained() bool {
+	select {
+	case <-opts.Drain:
+		return true
+	default:
+		return false
+	}
+}
+
+// withDrain returns a context canceled along with ctx or once Drain is
+// closed.
+func (opts *Options) withDrain(ctx context.Context) (context.Context, context.CancelFunc) {
+	drainCtx, cancel := context.WithCancel(ctx)
+	if opts.Drain != nil {
+		go func() {
+			select {
+			case <-opts.Drain:
+				cancel()
+			case <-drainCtx.Done():
+			}
+		}()
+	}
+	return drainCtx, cancel
+}
+
+// runErr returns the error a run ended early returns: that of ctx, or
+// ErrDrained.
+func (opts *Options) runErr(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if opts.drained() {
+		return ErrDrained
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package gocp
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// patternList matches paths relative to the source against glob patterns.
+// A pattern containing a slash is matched against the whole relative path,
+// any other pattern against each path's base name, so "*.log" and
+// "node_modules" apply at every depth.
+type patternList []string
+
+// newPatternList validates patterns and prepares them for matching.
+func newPatternList(patterns []string) (patternList, error) {
+	list := make(patternList, 0, len(patterns))
+	for _, pattern := range patterns {
+		pattern = normalizeMatchPath(strings.TrimSuffix(pattern, "/"))
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("Invalid pattern %q: %w", pattern, err)
+		}
+		list = append(list, pattern)
+	}
+	return list, nil
+}
+
+// match reports whether rel, a path relative to the source, matches any of
+// the patterns.
+func (list patternList) match(rel string) bool {
+	rel = normalizeMatchPath(rel)
+	base := path.Base(rel)
+	for _, pattern := range list {
+		name := base
+		if strings.Contains(pattern, "/") {
+			name = rel
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeMatchPath converts p to forward slashes, and to lower case on
+// Windows where file names are case-insensitive.
+func normalizeMatchPath(p string) string {
+	p = filepath.ToSlash(p)
+	if runtime.GOOS == "windows" {
+		p = strings.ToLower(p)
+	}
+	return p
+}
+
+// selects reports whether the file at rel, a path relative to the source,
+// passes Include and the time and size filters.
+func (r *report) selects(rel string, info os.FileInfo) bool {
+	if len(r.include) > 0 && !r.include.match(rel) {
+		return false
+	}
+	modTime := info.ModTime()
+	if !r.newerThan.IsZero() && !modTime.After(r.newerThan) {
+		return false
+	}
+	if !r.olderThan.IsZero() && !modTime.Before(r.olderThan) {
+		return false
+	}
+	if info.Mode().IsRegular() {
+		size := uint64(info.Size())
+		if size < r.minSize || (r.maxSize > 0 && size > r.maxSize) {
+			return false
+		}
+	}
+	return true
+}
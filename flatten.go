@@ -0,0 +1,49 @@
+package gocp
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+)
+
+// settleTargets returns where each of files goes when the target is
+// flattened, its names normalized or several sources merged into it, or ""
+// for the files left out because another file of the run ends up with the
+// same name. Such collisions are settled here rather than by the workers,
+// which could otherwise write the same target at once: with
+// ConflictOverwrite the last file wins, that of the last source, with
+// ConflictSkip the first one, and with ConflictRename each gets a free name.
+func settleTargets(opts *Options, files []fileEntry, rep *report) []string {
+	targets := make([]string, len(files))
+	taken := map[string]int{}
+	for i, file := range files {
+		var target string
+		if opts.Flatten {
+			target = opts.targetPath(filepath.Base(file.path))
+		} else {
+			relativePath, _ := filepath.Rel(file.root, file.path)
+			target = opts.targetPath(relativePath)
+		}
+		if j, ok := taken[target]; ok {
+			rep.collisions.Add(1)
+			switch opts.Conflict {
+			case ConflictSkip:
+				continue
+			case ConflictRename:
+				for n := 1; ; n++ {
+					candidate := numberedName(target, n)
+					_, inRun := taken[candidate]
+					if _, err := rep.dst.Lstat(candidate); !inRun && errors.Is(err, fs.ErrNotExist) {
+						target = candidate
+						break
+					}
+				}
+			default:
+				targets[j] = ""
+			}
+		}
+		taken[target] = i
+		targets[i] = target
+	}
+	return targets
+}
@@ -0,0 +1,167 @@
+package gocp
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// createFolderTree creates folders in the target with a pool of Threads
+// workers. They are created a depth at a time, the shallowest first, so the
+// parents of each folder exist by the time it is created and no two workers
+// make the same one.
+func createFolderTree(ctx context.Context, opts *Options, folders []fileEntry, rep *report) {
+	pool := NewThreadPool(int(opts.Threads))
+	defer pool.Stop()
+	for _, level := range folderDepths(folders) {
+		var created sync.WaitGroup
+		for _, folder := range level {
+			folder := folder
+			created.Add(1)
+			err := pool.Submit(ctx, func() {
+				defer created.Done()
+				createFolders(ctx, opts, []fileEntry{folder}, rep)
+			}, func(p *PanicError) {
+				rep.fail("creating directory", folder.path, p)
+			})
+			if err != nil {
+				created.Done()
+				break
+			}
+		}
+		// the next depth waits for its parents
+		created.Wait()
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// folderDepths groups folders by the depth of their path in the target,
+// the shallowest first, keeping their order within each depth.
+func folderDepths(folders []fileEntry) [][]fileEntry {
+	var levels [][]fileEntry
+	for _, folder := range folders {
+		depth := 0
+		if rel, _ := filepath.Rel(folder.root, folder.path); rel != "." {
+			depth = strings.Count(rel, string(filepath.Separator)) + 1
+		}
+		for len(levels) <= depth {
+			levels = append(levels, nil)
+		}
+		levels[depth] = append(levels[depth], folder)
+	}
+	return levels
+}
+
+func createFolders(ctx context.Context, opts *Options, folders []fileEntry, rep *report) {
+	for _, folder := range folders {
+		if ctx.Err() != nil {
+			return
+		}
+
+		relativePath, _ := filepath.Rel(folder.root, folder.path)
+		datFolder := opts.targetPath(relativePath)
+		if opts.DryRun {
+			opts.Logger.Info("Would create folder", "path", datFolder)
+			continue
+		}
+		if opts.TargetFS == nil {
+			datFolder = longPath(datFolder)
+		}
+		err := rep.dst.MkdirAll(datFolder, os.ModePerm)
+		if err != nil {
+			rep.fail("creating directory", folder.path, err)
+			continue
+		}
+		// keep the folder no more open than it ends up while copying,
+		// but writable by us until restoreFolders applies the exact mode.
+		if perm, ok := opts.dirPerm(folder.info.Mode()); ok {
+			if err := rep.dst.Chmod(datFolder, perm|0700); err != nil {
+				rep.fail("setting mode on directory", folder.path, err)
+			}
+		}
+		if opts.Owner {
+			if err := rep.preserveOwner(opts.Logger, datFolder, folder.info, false); err != nil {
+				rep.fail("setting owner on directory", folder.path, err)
+			}
+		}
+		if opts.Xattrs {
+			if err := rep.preserveXattrs(opts.Logger, folder.path, datFolder, false); err != nil {
+				rep.fail("setting extended attributes on directory", folder.path, err)
+			}
+		}
+	}
+}
+
+// nonEmptyFolders returns the folders holding one of files, directly or
+// further down, in their original order.
+func nonEmptyFolders(folders, files []fileEntry) []fileEntry {
+	used := map[string]bool{}
+	for _, file := range files {
+		for dir := filepath.Dir(file.path); !used[dir]; dir = filepath.Dir(dir) {
+			used[dir] = true
+			if dir == file.root || dir == filepath.Dir(dir) {
+				break
+			}
+		}
+	}
+
+	var kept []fileEntry
+	for _, folder := range folders {
+		if used[folder.path] {
+			kept = append(kept, folder)
+		}
+	}
+	return kept
+}
+
+// pruneFolders removes the source folders a move left empty, deepest first.
+// The sources themselves are kept.
+func pruneFolders(folders []fileEntry, sources []string) {
+	roots := map[string]bool{}
+	for _, source := range sources {
+		roots[source] = true
+	}
+	for i := len(folders) - 1; i >= 0; i-- {
+		if roots[folders[i].path] {
+			continue
+		}
+		// folders still holding something fail to be removed
+		os.Remove(folders[i].path)
+	}
+}
+
+// restoreFolders applies the source or forced modes, the ACLs and the
+// modification and creation times to the created folders, as opts asks.
+// The deepest folders go first, so a parent losing its write or search
+// permission doesn't get in the way of its children.
+func restoreFolders(opts *Options, folders []fileEntry, rep *report) {
+	for i := len(folders) - 1; i >= 0; i-- {
+		folder := folders[i]
+		relativePath, _ := filepath.Rel(folder.root, folder.path)
+		datFolder := opts.targetPath(relativePath)
+		if perm, ok := opts.dirPerm(folder.info.Mode()); ok {
+			if err := rep.dst.Chmod(datFolder, perm); err != nil {
+				rep.fail("setting mode on directory", folder.path, err)
+			}
+		}
+		// after the mode, which would rewrite the mask of the ACL
+		if opts.ACL {
+			rep.preserveACL(opts.Logger, folder.path, datFolder)
+		}
+		if opts.Preserve {
+			err := rep.dst.Chtimes(datFolder, folder.info.ModTime(), folder.info.ModTime())
+			if err != nil {
+				rep.fail("setting times on directory", folder.path, err)
+			}
+			if opts.TargetFS == nil {
+				if err := preserveBirthtime(datFolder, folder.info); err != nil {
+					rep.fail("setting creation time on directory", folder.path, err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package gocp
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// parentCheckTarget is the OS file system, recording the folders made
+// before their parent existed.
+type parentCheckTarget struct {
+	osTarget
+	mu       sync.Mutex
+	orphaned []string
+}
+
+func (t *parentCheckTarget) MkdirAll(name string, perm fs.FileMode) error {
+	if _, err := os.Stat(filepath.Dir(name)); err != nil {
+		t.mu.Lock()
+		t.orphaned = append(t.orphaned, name)
+		t.mu.Unlock()
+	}
+	return os.MkdirAll(name, perm)
+}
+
+// deepTree returns a tree of width chains of folders depth deep, with a file
+// at the bottom of each.
+func deepTree(width, depth int) map[string]string {
+	tree := map[string]string{}
+	for i := 0; i < width; i++ {
+		path := fmt.Sprintf("chain%02d", i)
+		for j := 1; j < depth; j++ {
+			path += fmt.Sprintf("/d%02d", j)
+		}
+		tree[path+"/file"] = path
+	}
+	return tree
+}
+
+func TestCreateFolderTreeParentsFirst(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source")
+	target := filepath.Join(dir, "target")
+	tree := deepTree(20, 40)
+	writeTree(t, source, tree)
+
+	dst := &parentCheckTarget{}
+	result := copyTree(t, Options{Source: source, Target: target, Threads: 32, TargetFS: dst})
+	if result.Folders != 20*40+1 {
+		t.Errorf("got %d folders, want %d", result.Folders, 20*40+1)
+	}
+	for _, name := range dst.orphaned {
+		t.Errorf("%s was created before its parent", name)
+	}
+	checkTree(t, target, tree)
+}
+
+func TestFolderDepths(t *testing.T) {
+	root := "src"
+	var folders []fileEntry
+	for _, rel := range []string{".", "a", "a/b", "a/b/c", "d", "d/e", "f"} {
+		folders = append(folders, fileEntry{filepath.Join(root, filepath.FromSlash(rel)), nil, root})
+	}
+
+	levels := folderDepths(folders)
+	want := [][]string{{"."}, {"a", "d", "f"}, {"a/b", "d/e"}, {"a/b/c"}}
+	if len(levels) != len(want) {
+		t.Fatalf("got %d depths, want %d", len(levels), len(want))
+	}
+	for depth, level := range levels {
+		var got []string
+		for _, folder := range level {
+			rel, _ := filepath.Rel(root, folder.path)
+			got = append(got, filepath.ToSlash(rel))
+		}
+		if strings.Join(got, " ") != strings.Join(want[depth], " ") {
+			t.Errorf("depth %d: got %v, want %v", depth, got, want[depth])
+		}
+	}
+}
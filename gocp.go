@@ -1,323 +1,880 @@
-package main
+// Package gocp copies directory trees using a pool of concurrent workers.
+package gocp
 
 import (
-	"flag"
+	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
-	"io"
-	"math"
+	"io/fs"
+	"log/slog"
 	"os"
 	"path/filepath"
-	"strings"
+	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/cheggaaa/pb/v3"
 	"github.com/dustin/go-humanize"
+)
 
-	"errors"
-	"log"
-	"net/http"
-	_ "net/http/pprof"
+// Options controls a copy run.
+type Options struct {
+	Source   string
+	Sources  []string // further sources merged into Target along with Source
+	Target   string
+	Threads  uint // files copied, and folders created, at once; DefaultThreads() is used when 0
+	Preserve bool // preserve file and folder mode bits and timestamps, creation times on macOS and Windows
+	Owner    bool // preserve the owner and group of files and folders on Unix
+	ACL      bool // preserve POSIX ACLs on Linux and the DACL on Windows
+	Xattrs   bool // preserve extended attributes on Linux and macOS
+	Update   bool // skip files whose target has the same size and is not older
+	DryRun   bool // only report what would be copied
+	Follow   bool // follow symbolic links instead of recreating them
+	Verify   bool // verify each copy against a SHA-256 of the source
+	Verbose  bool // log every file copied to Logger at debug level
+	Fsync    bool // flush each file and its folder entry to disk before moving on
+	Special  bool // recreate named pipes and device nodes instead of skipping them
+	// Hardlinks links the paths of a source file with several links to a
+	// single copy in the target, instead of copying it for each path.
+	Hardlinks bool
+	Force     bool // copy even if the files don't fit in the free space of the target
+	// Dedup copies files of the same content only once, hard linking the
+	// others to that copy. Candidates of the same size are compared by
+	// SHA-256 before the copy starts, so the source must not change during
+	// the run.
+	Dedup bool
+	// Checksums computes the SHA-256 of every file copied or linked for
+	// its FileRecord, as it is copied where possible.
+	Checksums bool
+
+	// FileMode and DirMode, when not 0, are the permission bits given to
+	// every copied file and created folder, instead of those of the source
+	// with Preserve or the defaults less the umask.
+	FileMode os.FileMode
+	DirMode  os.FileMode
+
+	// Conflict decides what happens to files that already exist in the
+	// target. They are overwritten by default.
+	Conflict ConflictPolicy
+
+	// Reflink decides whether files are cloned, sharing their data with the
+	// source, on file systems that support it. ReflinkAuto also lets Linux
+	// copy within the kernel.
+	Reflink ReflinkMode
+
+	// Exclude lists glob patterns of files and folders to leave out. A
+	// pattern containing a slash is matched against the path relative to
+	// the source, any other against the base name of each entry.
+	Exclude []string
+	// Include, when not empty, restricts the copy to files matching at
+	// least one of its patterns, which work like Exclude. Folders are not
+	// affected, and Exclude takes precedence.
+	Include []string
+	// IgnoreFiles names gitignore-style files, such as ".gitignore", that
+	// are honored in every folder of the source for that folder's subtree.
+	IgnoreFiles []string
+	// NewerThan and OlderThan, when not zero, restrict the copy to files
+	// modified after or before them. Together they select a time band.
+	NewerThan time.Time
+	OlderThan time.Time
+	// MinSize and MaxSize, when not zero, restrict the copy to regular
+	// files of at least and at most that many bytes.
+	MinSize uint64
+	MaxSize uint64
+	// List, when not nil, holds the paths of the files to copy, relative
+	// to Source, which must then be the only source and a folder. Only they
+	// and the folders leading to them are stat'ed, instead of walking the
+	// whole source, and a listed folder is created without what it holds.
+	// The filters still apply.
+	List []string
+
+	// BufferSize is the size of the buffer each worker copies through.
+	// DefaultBufferSize is used when it is 0.
+	BufferSize int
+	// ReadAhead, when not zero, is how many more buffers each file is read
+	// into while the current one is written, so reads and writes overlap.
+	// It pays off on network file systems, where each call waits on a round
+	// trip; see OnNetworkFS.
+	ReadAhead int
+	// Preallocate reserves the full size of each file in the target before
+	// copying it, where the platform and file system support it.
+	Preallocate bool
+	// Sparse leaves holes in the target files where the source has them,
+	// found with SEEK_DATA on Linux, or holds whole blocks of zeros, rather
+	// than writing the zeros out. Files are not split with it, and only
+	// cloned by a reflink, which keeps the holes.
+	Sparse bool
+	// Delta updates a target file that already exists with the size of
+	// the source in place, reading both in blocks and writing over only
+	// those that differ, instead of copying the whole file. It saves the
+	// writes of files mostly unchanged, such as disk images, but a file it
+	// was updating when the run stopped is left partly updated. Files on a
+	// TargetFS or compressed or decompressed are copied whole, and so are
+	// target files with other hard links on Unix, which an update in place
+	// would change as well. On Windows, such links are updated along with it.
+	Delta bool
+	// SplitThreshold, when not zero, is the size from which a file is
+	// divided into one byte range per thread, copied in parallel. No more
+	// than Threads ranges are copied at once, however many files are split.
+	SplitThreshold uint64
+	// MaxOpenFiles caps the files the workers hold open at once, counting
+	// the source and the target of each copy, so that Threads isn't bound
+	// by the open file limit. 0 picks a cap below the soft RLIMIT_NOFILE on
+	// Unix, and none elsewhere; a negative value means no cap.
+	MaxOpenFiles int
+	// Limit caps the combined write rate of all workers in bytes per
+	// second. 0 means unlimited.
+	Limit uint64
+
+	// Compress encodes every copied file and adds the extension of the
+	// compression to its name.
+	Compress Compression
+
+	// Decompress decodes the copied files ending in ".gz" or ".zst" and
+	// drops that extension from their name. Other files are copied as they
+	// are.
+	Decompress bool
+
+	// NoEmptyDirs only creates the folders that end up holding a file, so
+	// folders emptied by the filters are left out of the target.
+	NoEmptyDirs bool
+
+	// Normalize converts the names of the target to a Unicode normal form,
+	// so that names from macOS, which decomposes them, match those made on
+	// Linux or Windows. Files of the run ending up with the same name are
+	// settled by Conflict.
+	Normalize Normalization
+
+	// Order is the order the files are copied in. With more than one
+	// thread they still finish in whatever order their copies take.
+	Order Order
+
+	// KeepRoot copies each folder among the sources into a folder of its own
+	// name in Target, as "cp -r" does, rather than its contents directly.
+	KeepRoot bool
+	// TrailingSlash decides KeepRoot for each folder source by its path, as
+	// rsync does: "src/", ending in a separator, has its contents copied
+	// into Target, and "src" is copied into Target/src. "." and ".." copy
+	// their contents. It has no effect on the sources of a SourceFS.
+	TrailingSlash bool
+	// RootName, when not empty, is the name of a folder of Target the
+	// sources are copied into, as with KeepRoot under another name. It
+	// must be a single name, and names the root of a Tar archive as well.
+	RootName string
+
+	// NoCreate refuses to run unless the target folder exists, rather than
+	// creating it, so that a mistyped Target fails instead of starting a new
+	// tree. For a single file copied to a new name, the folder it is copied
+	// into must exist. The folder of RootName is still created.
+	NoCreate bool
+
+	// Flatten copies every file directly into Target, named after its base
+	// name. Files of the run with the same name are settled by Conflict.
+	Flatten bool
+
+	// Mirror deletes the files and folders of the target that don't exist
+	// in the source once everything was copied without errors. Entries
+	// matching Exclude are kept.
+	Mirror bool
+	// Move deletes each source file once it was copied, and verified if
+	// Verify is set, then removes the source folders left empty.
+	Move bool
+
+	// Retries is how many more times a file is tried after an error that
+	// may be transient, waiting 100ms before the first retry and twice as
+	// long before each next one.
+	Retries int
+
+	// FileTimeout fails a file whose copy, retries included, takes longer,
+	// and moves on to the next one. 0 means no limit.
+	FileTimeout time.Duration
+
+	// Manifest, when not empty, is the path of a file listing the target
+	// files copied so far, so a run interrupted or failing on some files
+	// can be started again and skip those still as it left them. It is
+	// appended to as files finish and deleted once a run completes without
+	// errors.
+	Manifest string
+
+	// Stream starts copying files as the scan finds them rather than once it
+	// is over, which saves waiting for it and holding every path of a large
+	// source. The free space is not checked first, and the totals given to
+	// Progress grow as the scan goes on. Stream has no effect with several
+	// sources, Flatten, Normalize, Dedup, NoEmptyDirs, List or an Order,
+	// which need the whole source scanned first.
+	Stream bool
+
+	// StopOnError aborts the run at the first error instead of carrying on
+	// with the remaining files.
+	StopOnError bool
+	// MaxErrors, when not zero, aborts the run the same way once that many
+	// errors occurred, taking them for a sign of something wrong with the
+	// whole run, such as a lost mount, rather than with the files.
+	MaxErrors int
+
+	// SourceFS, when not nil, is the file system Source and Sources are
+	// read from instead of the OS one, with paths such as "." or "dir/sub"
+	// as io/fs takes them, for instance to copy out of an embed.FS or a zip
+	// file. Only folders and regular files can be copied out of it, without
+	// reflinks or split copies; Follow, Move, Owner, Xattrs, ACL and Watch
+	// fail with ErrSourceFS.
+	SourceFS fs.FS
+	// TargetFS, when not nil, is the file system Target is written to
+	// instead of the OS one, such as a remote server. Files are not
+	// cloned, split or preallocated in it, the free space is not checked,
+	// and Owner, Xattrs, ACL, Hardlinks, Dedup, Special, Mirror and
+	// Manifest fail with ErrTargetFS, as do Compare and Watch.
+	TargetFS TargetFS
+
+	// Progress is notified as files are processed. It may be nil.
+	Progress Progress
+	// Drain, when closed, stops the run like canceling its context, except
+	// that the files already being copied are finished rather than
+	// discarded, and Copy returns ErrDrained. It may be nil.
+	Drain <-chan struct{}
+
+	// FileLog receives the outcome of every file. It may be nil.
+	FileLog FileLog
+
+	// OnFileStart, if set, is called as a worker begins copying or linking
+	// a file, with its source path and size. Files skipped by Update,
+	// Conflict or a resume are not started, nor any in a dry run.
+	OnFileStart func(path string, size int64)
+	// OnFileDone, if set, is called once a started file is over, with the
+	// bytes of the file copied or linked, or 0 and why it failed.
+	OnFileDone func(path string, bytes int64, err error)
+	// OnScanComplete, if set, is called once the sources were scanned, with
+	// the number of files found and their total size.
+	OnScanComplete func(files, bytes uint64)
+	// The hooks are called from several workers at once and must be safe
+	// for concurrent use.
+
+	// Logger receives status records, the dry-run plan at info level and
+	// every error as it occurs. It may be nil.
+	Logger *slog.Logger
+}
+
+// ConflictPolicy is how a file already present in the target is handled.
+type ConflictPolicy int
+
+const (
+	ConflictOverwrite ConflictPolicy = iota // replace the existing file
+	ConflictSkip                            // leave it and count the file as skipped
+	ConflictRename                          // copy to a free name such as "name (1).ext"
+)
+
+// ReflinkMode is whether files are cloned instead of copied.
+type ReflinkMode int
+
+const (
+	// ReflinkAuto clones when possible and copies otherwise. Files are not
+	// cloned when Verify or Limit need to see the data as it is copied.
+	ReflinkAuto   ReflinkMode = iota
+	ReflinkAlways             // fail the files that can't be cloned
+	ReflinkNever              // always copy through the buffer
 )
 
-type Args struct {
-	Source  string
-	Target  string
-	Threads uint
+// Progress is notified as a run advances. Increment and Add are called from
+// multiple workers and must be safe for concurrent use.
+type Progress interface {
+	// Start is called once the folders exist, with the number of files and
+	// bytes to copy.
+	Start(files, bytes uint64)
+	// Increment is called after each file, whether or not it was copied.
+	Increment()
+	// Add is called as data is copied with the number of bytes written.
+	// Skipped files are added in one go.
+	Add(n int64)
+	// Finish is called once all files have been processed.
+	Finish()
+}
+
+// FileProgress may be implemented by a Progress to also follow each file
+// as it is copied.
+type FileProgress interface {
+	// StartFile is called when a worker begins copying path of size bytes.
+	// The bytes written are reported to the returned tracker as well as to
+	// Add, and its Done is called once the file is finished.
+	StartFile(path string, size int64) FileTracker
+}
+
+// FileTracker follows the copy of a single file for a FileProgress.
+type FileTracker interface {
+	Add(n int64)
+	Done()
 }
 
-var filePool = sync.Pool{
-	New: func() interface{} {
-		return &os.File{}
-	},
+// TotalProgress may be implemented by a Progress to learn of the files found
+// after Start, as with Options.Stream.
+type TotalProgress interface {
+	// SetTotal is called with the number of files and bytes found so far,
+	// one call at a time.
+	SetTotal(files, bytes uint64)
 }
 
-type ThreadPool struct {
-	tasks chan func()
-	wg    sync.WaitGroup
+// Result summarizes a run.
+type Result struct {
+	Files       uint64 // files found in the source
+	Folders     uint64 // folders found in the source
+	Bytes       uint64 // total size of the files found
+	Copied      uint64 // files copied, or that would be copied in a dry run
+	CopiedBytes uint64 // bytes copied, or that would be copied in a dry run
+	Skipped     uint64 // files skipped as up to date or by ConflictSkip
+	Excluded    uint64 // files and folders left out by the filters
+	Deleted     uint64 // target files and folders deleted by Mirror
+	Retried     uint64 // files that needed more than one attempt
+	Specials    uint64 // pipes, sockets and devices skipped
+	Linked      uint64 // copied files that were linked to an earlier copy
+	Collisions  uint64 // files with the same target as another one, of another source or under Flatten or Normalize
+	Deduped     uint64 // copied files linked to an identical copy by Dedup
+
+	DedupedBytes uint64 // bytes not written thanks to Dedup
+
+	SkippedBytes uint64 // size of the skipped files
+	FailedBytes  uint64 // size of the files that failed to copy
+
+	// Mismatches lists the source files whose copy failed verification.
+	Mismatches []string
+	// Unreadable lists the source files and folders the scan left out for
+	// lack of permission to read them. They are not counted as errors.
+	Unreadable []string
+	// Errors holds every error that occurred, in no particular order.
+	Errors []*CopyError
+	// Aborted is set when StopOnError or MaxErrors ended the run early.
+	Aborted bool
+
+	Elapsed time.Duration
+	// ScanElapsed, FoldersElapsed and CopyElapsed are the parts of Elapsed
+	// spent scanning the sources, creating the folders and copying the
+	// files, hashing them for Dedup included. With Stream, the folders are
+	// created and the files copied during the scan, so CopyElapsed covers
+	// the scan as well and FoldersElapsed is 0. The rest of Elapsed goes to
+	// the checks before the copy, Mirror and restoring the folders.
+	ScanElapsed    time.Duration
+	FoldersElapsed time.Duration
+	CopyElapsed    time.Duration
 }
 
-func main() {
-	// implant pprof profiler
-	go func() {
-		log.Println(http.ListenAndServe("localhost:6060", nil))
-	}()
+// maxDefaultThreads caps DefaultThreads, since copying is mostly bound by
+// I/O rather than CPU and more threads only add contention.
+const maxDefaultThreads = 16
 
-	// Define command-line flags
-	source := flag.String("s", "", "Source directory path")
-	target := flag.String("t", "", "Target directory path")
-	threads := flag.Uint("mt", 0, "Number of threads to use")
+// DefaultThreads returns the number of threads used when Options.Threads is 0.
+func DefaultThreads() uint {
+	return uint(min(2*runtime.NumCPU(), maxDefaultThreads))
+}
 
-	// Parse command-line arguments
-	flag.Parse()
+// CopyError is a failure on a single path of the source tree.
+type CopyError struct {
+	Op   string // what was being done, e.g. "copying file"
+	Path string // the source path involved
+	Err  error
+}
 
-	// Check if required flags are provided
-	if *source == "" || *target == "" || *threads == 0 {
-		fmt.Println("Usage: -source <source_directory> -target <target_directory> -threads <number_of_threads>")
-		return
+func (e *CopyError) Error() string {
+	return "Error " + e.Op + " " + e.Path + ": " + e.Err.Error()
+}
+
+func (e *CopyError) Unwrap() error {
+	return e.Err
+}
+
+// ErrFlattenMirror is returned by Copy when both Flatten and Mirror are set,
+// since a flattened target has nothing in common with the source layout.
+var ErrFlattenMirror = errors.New("Flatten and Mirror cannot be used together.")
+
+// ErrCompressDecompress is returned by Copy when both Compress and Decompress
+// are set.
+var ErrCompressDecompress = errors.New("Compress and Decompress cannot be used together.")
+
+// ErrSourceNotDir is returned by Copy when no source is given or a source is
+// neither a directory nor a file.
+var ErrSourceNotDir = errors.New("Source must be a directory or a file.")
+
+// ErrSourceNotFound is returned by Copy when a source doesn't exist.
+var ErrSourceNotFound = errors.New("Source does not exist.")
+
+// ErrTargetNotDir is returned by Copy when the target exists but is not a
+// directory, while the sources are folders or more than one file.
+var ErrTargetNotDir = errors.New("Target exists and is not a directory.")
+
+// ErrTargetNotFound is returned by Copy when NoCreate is set and the target
+// folder doesn't exist.
+var ErrTargetNotFound = errors.New("Target does not exist.")
+
+// ErrSourceInTarget is returned by Copy and Watch when a source folder is
+// the target or inside it, where the copy would overwrite the files it
+// reads. A target inside a source is left out of the walk instead.
+var ErrSourceInTarget = errors.New("Source cannot be the target or inside it.")
+
+// fileEntry is a path discovered during the scan together with the
+// information gathered for it, so it doesn't have to be stat'ed again, and
+// the source folder it is copied relative to.
+type fileEntry struct {
+	path string
+	info os.FileInfo
+	root string
+}
+
+// report holds the state shared by the workers of a run and collects its
+// outcome.
+type report struct {
+	maxErrors   int // errors that abort the run, or 0
+	cancel      context.CancelFunc
+	aborted     atomic.Bool
+	limiter     *rateLimiter
+	files       *fileLimit    // the files the workers may hold open
+	ranges      chan struct{} // the ranges of split files copied at once, Threads at most
+	exclude     patternList
+	include     patternList
+	ignoreFiles []string
+	special     bool
+	newerThan   time.Time
+	olderThan   time.Time
+	minSize     uint64
+	maxSize     uint64
+	log         *slog.Logger
+	src         sourceFS // where the sources are read from
+	dst         TargetFS // where the target is written to
+	target      string   // the resolved target path, left out of the walks
+
+	// keepsRoot reports whether the entries of a folder source are named
+	// after it
+	keepsRoot func(source string) bool
+
+	manifest     *manifest // files completed by earlier runs, if resuming
+	hardlinks    hardlinks
+	dupes        map[string][sha256.Size]byte // content of the files Dedup may link
+	ownerWarning sync.Once
+	xattrWarning sync.Once
+	aclWarning   sync.Once
+
+	copied       atomic.Uint64
+	skipped      atomic.Uint64
+	excluded     atomic.Uint64
+	deleted      atomic.Uint64
+	retried      atomic.Uint64
+	specials     atomic.Uint64
+	linked       atomic.Uint64
+	collisions   atomic.Uint64
+	deduped      atomic.Uint64
+	dedupedBytes atomic.Uint64
+	bytes        atomic.Uint64
+	skippedBytes atomic.Uint64
+	failedBytes  atomic.Uint64
+
+	// how long each phase of Copy took, as it ends
+	scanElapsed, foldersElapsed, copyElapsed time.Duration
+
+	mu         sync.Mutex
+	errors     []*CopyError
+	mismatches []string
+	unreadable []string
+}
+
+// Copy copies opts.Source and opts.Sources into opts.Target. Failures on
+// individual files and folders don't stop the run; they are collected in the
+// returned Result. An error is returned when the run can't start, or with the
+// partial Result when ctx is canceled, in which case files still being copied
+// are discarded, or when Drain is closed, in which case they are finished
+// first.
+func Copy(ctx context.Context, opts Options) (Result, error) {
+	if opts.Threads == 0 {
+		opts.Threads = DefaultThreads()
+	}
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = DefaultBufferSize
+	}
+	if opts.Progress == nil {
+		opts.Progress = noProgress{}
+	}
+	if opts.Logger == nil {
+		opts.Logger = discardLogger
+	}
+	if err := opts.applyRootName(); err != nil {
+		return Result{}, err
 	}
 
-	// Use the provided arguments
-	args := Args{
-		Source:  *source,
-		Target:  *target,
-		Threads: *threads,
+	// start timer
+	start := time.Now()
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	rep, err := newReport(&opts, cancel)
+	if err != nil {
+		return Result{}, err
 	}
+	// no more files are taken once queueCtx is done, while those already
+	// taken are copied on with runCtx
+	queueCtx, stopQueue := opts.withDrain(runCtx)
+	defer stopQueue()
 
-	sourcePath := args.Source
-	targetPath := args.Target
+	if opts.Flatten && opts.Mirror {
+		return Result{}, ErrFlattenMirror
+	}
+	if opts.Compress != CompressNone && opts.Decompress {
+		return Result{}, ErrCompressDecompress
+	}
+
+	// finished is set once every file was handled, so that the manifest is
+	// kept for runs that stopped early
+	finished := false
+	if opts.Manifest != "" {
+		if rep.manifest, err = openManifest(opts.Manifest, opts.DryRun); err != nil {
+			return Result{}, err
+		}
+		defer func() {
+			complete := finished && queueCtx.Err() == nil && len(rep.errors) == 0
+			if err := rep.manifest.close(complete); err != nil {
+				opts.Logger.Warn("Failed to close the resume manifest", "error", err)
+			}
+		}()
+	}
+
+	sources := opts.sources()
+	targetPath := opts.Target
+
+	// check if the sources existed, and whether they are folders.
+	if err := opts.checkSourceFS(); err != nil {
+		return Result{}, err
+	}
+	if err := opts.checkTargetFS(); err != nil {
+		return Result{}, err
+	}
+	sourceInfos, err := statSources(rep.src, sources)
+	if err != nil {
+		return Result{}, err
+	}
+	if err := opts.checkList(sourceInfos); err != nil {
+		return Result{}, err
+	}
+	if opts.NoCreate {
+		single := len(sources) == 1 && !sourceInfos[0].IsDir()
+		if err := opts.checkExists(rep.dst, single); err != nil {
+			return Result{}, err
+		}
+	}
 
-	// check if the source folder existed.
-	if _, err := os.Stat(sourcePath); os.IsNotExist(err) {
-		fmt.Println("Source must be a directory.")
-		return
+	// a single file needs none of the folder machinery
+	if len(sources) == 1 && !sourceInfos[0].IsDir() {
+		if opts.RootName != "" && !opts.DryRun {
+			// the file goes into the folder, not in its place
+			if err := rep.dst.MkdirAll(opts.Target, os.ModePerm); err != nil {
+				return Result{}, fmt.Errorf("Failed to create target folder: %w", err)
+			}
+		}
+		if opts.OnScanComplete != nil {
+			opts.OnScanComplete(1, uint64(sourceInfos[0].Size()))
+		}
+		copyStart := time.Now()
+		copySingleFile(runCtx, &opts, sources[0], sourceInfos[0], rep)
+		rep.copyElapsed = time.Since(copyStart)
+		finished = true
+		return rep.result(1, uint64(sourceInfos[0].Size()), 0, start), opts.runErr(ctx)
 	}
 
 	// create the target folder if it doesn't exist.
-	if _, err := os.Stat(targetPath); os.IsNotExist(err) {
-		os.MkdirAll(targetPath, os.ModePerm)
+	if err := checkTarget(rep.dst, targetPath); err != nil {
+		return Result{}, err
+	}
+	if opts.SourceFS == nil && opts.TargetFS == nil {
+		if err := checkOverlap(sources, sourceInfos, targetPath); err != nil {
+			return Result{}, err
+		}
+	}
+	if _, err := rep.dst.Stat(targetPath); errors.Is(err, fs.ErrNotExist) && !opts.DryRun {
+		if err := rep.dst.MkdirAll(targetPath, os.ModePerm); err != nil {
+			return Result{}, fmt.Errorf("Failed to create target folder: %w", err)
+		}
+		// not more open than createFolders would leave it, from the start
+		if perm, ok := opts.rootPerm(sources, sourceInfos); ok {
+			if err := rep.dst.Chmod(targetPath, perm|0700); err != nil {
+				return Result{}, fmt.Errorf("Failed to set target folder mode: %w", err)
+			}
+		}
 	}
 
-	// start timer
-	start := time.Now()
+	if opts.streams() {
+		copyStart := time.Now()
+		totalFileCount, totalSize, folders := streamSources(runCtx, queueCtx, &opts, sources, sourceInfos, rep)
+		rep.copyElapsed = time.Since(copyStart)
+		settleTarget(queueCtx, &opts, sources, folders, folders, rep)
+		finished = true
+		return rep.result(totalFileCount, totalSize, len(folders), start), opts.runErr(ctx)
+	}
 
 	// get file and folder lists and total file count and folder count
-	totalFileCount, totalSize, folders, files := getFilesAndDir(sourcePath)
+	// of all sources, so a single pool schedules across them.
+	scanStart := time.Now()
+	totalFileCount, totalSize, folders, files := scanSources(queueCtx, &opts, sources, sourceInfos, rep)
 	folderCount := len(folders)
+	// files of several sources with the same path are settled in the order
+	// of the sources, whatever the order they are copied in
+	var targets []string
+	if opts.Flatten || opts.Normalize != NormalizeNone || len(sources) > 1 {
+		targets = settleTargets(&opts, files, rep)
+	}
+	opts.sortFiles(files, targets)
+	rep.scanElapsed = time.Since(scanStart)
+	if opts.OnScanComplete != nil && queueCtx.Err() == nil {
+		opts.OnScanComplete(totalFileCount, totalSize)
+	}
 
 	var elapsed time.Duration = time.Since(start)
-	fmt.Printf("Size %s of total files / folders: %d / %d.\tElapsed time: %v\n",
-		humanize.IBytes(totalSize), totalFileCount, folderCount, elapsed)
-
-	// split it into chunks by the thread number
-	folderChunkSize := uint64(folderCount) / uint64(args.Threads)
-	folderChunks := chunkArray(folders, int(math.Round((float64(folderChunkSize)))))
-
-	// Create a thread poolFolder with 4 workers
-	poolFolder := NewThreadPool(int(len(folderChunks)))
-	defer poolFolder.Stop()
-
-	// Create all folders in parallel
-	i := 0
-	for _, folders := range folderChunks {
-		folders := folders
-		err := poolFolder.Submit(func() {
-			createFolders(sourcePath, targetPath, folders)
-		})
-		i++
-		if err != nil {
-			// retry it after 3 seconds
-			time.Sleep(1 * time.Second)
-			createFolders(sourcePath, targetPath, folders)
+	opts.Logger.Info("Scanned the source", "size", humanize.IBytes(totalSize),
+		"files", totalFileCount, "folders", folderCount, "elapsed", elapsed)
+
+	// give up before anything is copied rather than fill the target
+	if !opts.Force && !opts.DryRun && opts.TargetFS == nil && queueCtx.Err() == nil {
+		if err := checkSpace(&opts, files, totalSize); err != nil {
+			return rep.result(totalFileCount, totalSize, folderCount, start), err
 		}
 	}
 
-	elapsed = time.Since(start)
-	fmt.Printf("Created all folders in destination.\tElapsed time: %v\n", elapsed)
+	createdFolders := folders
+	if opts.NoEmptyDirs {
+		createdFolders = nonEmptyFolders(folders, files)
+	}
 
-	// create a progress bar
-	barMain := pb.StartNew(int(totalFileCount))
-	barMain.SetTemplateString(`{{string . "prefix"}} {{counters . }} {{bar . "[" "=" ">" "-" "]" | green}} {{percent . }} {{etime . }} {{string . "suffix"}}`)
-	barMain.Start()
+	// all folders must exist before any file is copied into them; a
+	// flattened target has no folders of its own
+	foldersStart := time.Now()
+	if !opts.Flatten {
+		createFolderTree(queueCtx, &opts, createdFolders, rep)
+	}
+	rep.foldersElapsed = time.Since(foldersStart)
 
-	fileChunkSize := totalFileCount / uint64(args.Threads)
-	fileChunks := chunkArray(files, int(math.Round((float64(fileChunkSize)))))
+	elapsed = time.Since(start)
+	opts.Logger.Info("Created all folders in destination", "elapsed", elapsed)
 
-	// Create a thread pool for copying threads
-	poolCopy := NewThreadPool(int(len(fileChunks)))
+	if queueCtx.Err() != nil {
+		return rep.result(totalFileCount, totalSize, folderCount, start), opts.runErr(ctx)
+	}
 
-	for _, files := range fileChunks {
-		files := files
-		err := poolCopy.Submit(func() {
-			copyFiles(sourcePath, targetPath, files, barMain)
-		})
+	copyStart := time.Now()
+	if opts.Dedup && !opts.DryRun {
+		rep.dupes = findDuplicates(queueCtx, &opts, files, rep)
+	}
 
+	opts.Progress.Start(totalFileCount, totalSize)
+
+	// Create a thread pool for copying threads. Files are queued one by one
+	// so every worker stays busy until the last file is taken.
+	poolCopy := NewThreadPool(int(opts.Threads))
+
+	for i, file := range files {
+		file := file
+		relativePath, _ := filepath.Rel(file.root, file.path)
+		destFile := filepath.Join(opts.Target, relativePath)
+		if targets != nil {
+			destFile = targets[i]
+			if destFile == "" {
+				skipEntry(&opts, file, "", time.Now(), rep)
+				continue
+			}
+		}
+		err := poolCopy.Submit(queueCtx, func() {
+			if opts.drained() {
+				// queued, but not started yet
+				return
+			}
+			copyEntry(runCtx, &opts, file, destFile, rep)
+		}, func(p *PanicError) {
+			rep.fail("copying file", file.path, p)
+			opts.Progress.Increment()
+		})
 		if err != nil {
-			time.Sleep(1 * time.Second)
-			copyFiles(sourcePath, targetPath, files, barMain)
+			break
 		}
 	}
 
 	poolCopy.Stop()
-	barMain.Finish()
+	opts.Progress.Finish()
+	rep.copyElapsed = time.Since(copyStart)
 
-	elapsed = time.Since(start)
-	fmt.Printf("\nTotal Elapsed time: %v\n\n", elapsed)
+	settleTarget(queueCtx, &opts, sources, folders, createdFolders, rep)
+	finished = true
+	return rep.result(totalFileCount, totalSize, folderCount, start), opts.runErr(ctx)
 }
 
-// NewThreadPool creates a new thread pool with a specified number of workers.
-func NewThreadPool(numWorkers int) *ThreadPool {
-	pool := &ThreadPool{
-		tasks: make(chan func()),
-	}
-	for i := 0; i < numWorkers; i++ {
-		pool.wg.Add(1)
-		go func() {
-			defer pool.wg.Done()
-			for task := range pool.tasks {
-				task()
-			}
-		}()
+// settleTarget finishes a run once its files were copied: Mirror and Move
+// delete what they must, then the modes and times of the created folders
+// are restored.
+func settleTarget(ctx context.Context, opts *Options, sources []string, folders, createdFolders []fileEntry, rep *report) {
+	// only delete from the target once it is known to hold everything,
+	// and before the folder times are restored, since deleting changes them.
+	if opts.Mirror && ctx.Err() == nil {
+		if len(rep.errors) == 0 {
+			mirrorTarget(ctx, opts, sources, rep)
+		} else {
+			opts.Logger.Warn("Not deleting extraneous files, since errors occurred")
+		}
 	}
-	return pool
-}
 
-// Submit submits a task to the thread pool.
-func (pool *ThreadPool) Submit(task func()) error {
-	select {
-	case pool.tasks <- task:
-		return nil
-	default:
-		return ErrTaskQueueFull
+	if opts.Move && !opts.DryRun && ctx.Err() == nil {
+		pruneFolders(folders, sources)
 	}
-}
-
-var ErrTaskQueueFull = errors.New("task queue is full")
-
-// Stop stops the thread pool, waiting for all tasks to complete.
-func (pool *ThreadPool) Stop() {
-	close(pool.tasks)
-	pool.wg.Wait()
-}
 
-func (pool *ThreadPool) Wait() {
-	pool.wg.Wait()
+	// restore folder modes and timestamps last, since copying files into
+	// them updates their modification time and may need write permission.
+	if (opts.Preserve || opts.ACL || opts.DirMode != 0) && !opts.DryRun && !opts.Flatten && ctx.Err() == nil {
+		restoreFolders(opts, createdFolders, rep)
+	}
 }
 
-func copyFiles(sourcePath, targetPath string, files []string, barMain *pb.ProgressBar) {
-	for _, file := range files {
-		extractedFilename := strings.Replace(file, sourcePath, "", 1)
-		destFile := filepath.Join(targetPath, extractedFilename)
-		// err := copyFileWithPool(file, destFile)
-		err := copyFile(file, destFile)
-		if err != nil {
-			fmt.Printf("Error copying file %s: %v\n", file, err)
+// sources returns Source followed by Sources, leaving out empty ones.
+func (opts *Options) sources() []string {
+	var sources []string
+	for _, source := range append([]string{opts.Source}, opts.Sources...) {
+		if source == "" {
+			continue
+		}
+		if base := filepath.Base(source); opts.keepsRoot(source) && opts.SourceFS == nil && (base == "." || base == "..") {
+			// the name to keep is that of the folder it stands for
+			if abs, err := filepath.Abs(source); err == nil {
+				source = abs
+			}
 		}
-		barMain.Increment()
+		sources = append(sources, source)
 	}
+	return sources
 }
 
-func copyFileWithPool(src, dst string) error {
-	// Get a file handle from the file pool
-	srcFile := filePool.Get().(*os.File)
-	defer filePool.Put(srcFile)
-
-	// open the source file
-	var err error
-	srcFile, err = os.Open(src)
-	if err != nil {
-		return fmt.Errorf("Cannot open source file: %w", err)
-	}
-	defer srcFile.Close()
-
-	// create the target file
-	dstFile, err := os.Create(dst)
-	if err != nil {
-		return fmt.Errorf("Failed to create target file: %w", err)
-	}
-	defer dstFile.Close()
-
-	// copy file with buffer
-	_, err = io.CopyBuffer(dstFile, srcFile, make([]byte, 32*1024))
-	// buf := make([]byte, 32*1024)
-	// var totalBytes int64
-	// for {
-	// 	n, err := srcFile.Read(buf)
-	// 	if err != nil && err != io.EOF {
-	// 		return fmt.Errorf("Failed to read source file: %w", err)
-	// 	}
-	// 	if n == 0 {
-	// 		break
-	// 	}
-
-	// 	if _, err := dstFile.Write(buf[:n]); err != nil {
-	// 		return fmt.Errorf("Failed to write to target file: %w", err)
-	// 	}
-
-	// 	totalBytes += int64(n)
-	// 	barTransfer.Add64(int64(n)) // 진행률 표시줄에 전송된 바이트 수를 업데이트합니다.
-	// }
-	// _, err = io.Copy(dstFile, srcFile)
-	if err != nil {
-		return fmt.Errorf("Failed to copy file: %w", err)
+// copySingleFile copies a source that is a file to the target, or into the
+// target when it is an existing folder.
+func copySingleFile(ctx context.Context, opts *Options, source string, info os.FileInfo, rep *report) {
+	destFile := opts.Target
+	if targetInfo, err := rep.dst.Stat(opts.Target); err == nil && targetInfo.IsDir() {
+		destFile = opts.targetPath(filepath.Base(source))
 	}
 
-	return nil
+	opts.Progress.Start(1, uint64(info.Size()))
+	copyEntry(ctx, opts, fileEntry{source, info, filepath.Dir(source)}, destFile, rep)
+	opts.Progress.Finish()
 }
 
-func copyFile(src, dst string) error {
-	// open the source file
-	srcFile, err := os.Open(src)
+// newReport prepares the shared state of a run with opts, which cancel
+// aborts.
+func newReport(opts *Options, cancel context.CancelFunc) (*report, error) {
+	exclude, err := newPatternList(opts.Exclude)
 	if err != nil {
-		return fmt.Errorf("Cannot open source file: %w", err)
+		return nil, err
 	}
-	defer srcFile.Close()
-
-	// create the target file
-	dstFile, err := os.Create(dst)
+	include, err := newPatternList(opts.Include)
 	if err != nil {
-		return fmt.Errorf("Failed to create target file: %w", err)
+		return nil, err
 	}
-	defer dstFile.Close()
 
-	// copy file
-	_, err = io.Copy(dstFile, srcFile)
-	if err != nil {
-		return fmt.Errorf("Failed to copy file: %w", err)
+	rep := &report{
+		maxErrors:   opts.MaxErrors,
+		cancel:      cancel,
+		exclude:     exclude,
+		include:     include,
+		ignoreFiles: opts.IgnoreFiles,
+		special:     opts.Special,
+		newerThan:   opts.NewerThan,
+		olderThan:   opts.OlderThan,
+		minSize:     opts.MinSize,
+		maxSize:     opts.MaxSize,
+		log:         opts.Logger,
+		src:         opts.sourceFS(),
+		dst:         opts.targetFS(),
+		keepsRoot:   opts.keepsRoot,
+		ranges:      make(chan struct{}, max(opts.Threads, 1)),
 	}
-
-	return nil
+	if opts.SourceFS == nil && opts.TargetFS == nil && opts.Target != "" {
+		rep.target, _ = resolvePath(opts.Target)
+	}
+	if opts.StopOnError {
+		rep.maxErrors = 1
+	}
+	if opts.Limit > 0 {
+		rep.limiter = newRateLimiter(opts.Limit)
+	}
+	switch {
+	case opts.MaxOpenFiles > 0:
+		rep.files = newFileLimit(opts.MaxOpenFiles)
+	case opts.MaxOpenFiles == 0:
+		rep.files = newFileLimit(defaultFileLimit())
+	}
+	return rep, nil
 }
 
-func getFilesAndDir(path string) (uint64, uint64, []string, []string) {
-	var filesCount, totalSize uint64
-	var directories []string
-	var files []string
-
-	err := filepath.WalkDir(path, func(pathInfo string, d os.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-
-		if d.IsDir() {
-			directories = append(directories, pathInfo)
-		} else {
-			filesCount++
-			info, err := d.Info()
-			if err != nil {
-				return err
-			}
-			totalSize += uint64(info.Size())
-			files = append(files, pathInfo)
-		}
-
-		return nil
-	})
-	if err != nil {
-		fmt.Println("Error counting files:", err)
+// result builds the Result of a run from what was collected.
+func (r *report) result(files, bytes uint64, folders int, start time.Time) Result {
+	return Result{
+		Files:        files,
+		Folders:      uint64(folders),
+		Bytes:        bytes,
+		Copied:       r.copied.Load(),
+		CopiedBytes:  r.bytes.Load(),
+		Skipped:      r.skipped.Load(),
+		Excluded:     r.excluded.Load(),
+		Deleted:      r.deleted.Load(),
+		Retried:      r.retried.Load(),
+		Specials:     r.specials.Load(),
+		Linked:       r.linked.Load(),
+		Collisions:   r.collisions.Load(),
+		Deduped:      r.deduped.Load(),
+		DedupedBytes: r.dedupedBytes.Load(),
+		SkippedBytes: r.skippedBytes.Load(),
+		FailedBytes:  r.failedBytes.Load(),
+		Mismatches:   r.mismatches,
+		Unreadable:   r.unreadable,
+		Errors:       r.errors,
+		Aborted:      r.aborted.Load(),
+		Elapsed:      time.Since(start),
+
+		ScanElapsed:    r.scanElapsed,
+		FoldersElapsed: r.foldersElapsed,
+		CopyElapsed:    r.copyElapsed,
 	}
-	return filesCount, totalSize, directories, files
 }
 
-func createFolders(sourcePath string, targetPath string, folders []string) {
-	for _, folder := range folders {
-		relativePath, _ := filepath.Rel(sourcePath, folder)
-		datFolder := filepath.Join(targetPath, relativePath)
-		err := os.MkdirAll(datFolder, os.ModePerm)
-		if err != nil {
-			fmt.Printf("Error creating directory %s: %v\n", datFolder, err)
-		}
+// fail records an error on path, aborting the run if requested.
+func (r *report) fail(op, path string, err error) {
+	r.mu.Lock()
+	r.errors = append(r.errors, &CopyError{Op: op, Path: path, Err: err})
+	failed := len(r.errors)
+	r.mu.Unlock()
+	r.log.Error("Error "+op, "path", path, "error", err)
+
+	if r.maxErrors > 0 && failed >= r.maxErrors {
+		r.aborted.Store(true)
+		r.cancel()
 	}
 }
 
-func chunkArray(entities []string, chunkSize int) [][]string {
-	var chunks [][]string
-	for i := 0; i < len(entities); i += chunkSize {
-		end := i + chunkSize
-		if end > len(entities) {
-			end = len(entities)
-		}
-		chunks = append(chunks, entities[i:end])
-	}
-	return chunks
+// mismatch records a file whose copy didn't match the source.
+func (r *report) mismatch(path string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.mismatches = append(r.mismatches, path)
 }
+
+// skipUnreadable records a source path left out for lack of permission.
+func (r *report) skipUnreadable(path string, err error) {
+	r.mu.Lock()
+	r.unreadable = append(r.unreadable, path)
+	r.mu.Unlock()
+	r.log.Warn("Skipping unreadable path", "path", path, "error", err)
+}
+
+// noProgress is used when Options.Progress is nil.
+type noProgress struct{}
+
+func (noProgress) Start(uint64, uint64) {}
+func (noProgress) Increment()           {}
+func (noProgress) Add(int64)            {}
+func (noProgress) Finish()              {}
+
+// discardLogger is the Logger of runs given none.
+var discardLogger = slog.New(discardHandler{})
+
+// discardHandler is a slog.Handler dropping every record.
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
@@ -0,0 +1,176 @@
+package gocp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestMergeSameNames(t *testing.T) {
+	const n = 300
+	tests := []struct {
+		conflict ConflictPolicy
+		order    Order
+		from     string // the source whose files land under their own name
+		renamed  bool
+	}{
+		{ConflictOverwrite, OrderScan, "two", false},
+		{ConflictOverwrite, OrderSize, "two", false},
+		{ConflictSkip, OrderScan, "one", false},
+		{ConflictRename, OrderScan, "one", true},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%v/%v", test.conflict, test.order), func(t *testing.T) {
+			dir := t.TempDir()
+			want := map[string]string{}
+			for _, source := range []string{"one", "two"} {
+				tree := map[string]string{}
+				for i := 0; i < n; i++ {
+					// the second source's files are the larger ones
+					tree[fmt.Sprintf("f%03d", i)] = strings.Repeat(source, i+1)
+				}
+				writeTree(t, filepath.Join(dir, source), tree)
+			}
+			for i := 0; i < n; i++ {
+				want[fmt.Sprintf("f%03d", i)] = strings.Repeat(test.from, i+1)
+				if test.renamed {
+					want[fmt.Sprintf("f%03d (1)", i)] = strings.Repeat("two", i+1)
+				}
+			}
+
+			target := filepath.Join(dir, "target")
+			copyTree(t, Options{
+				Source:   filepath.Join(dir, "one"),
+				Sources:  []string{filepath.Join(dir, "two")},
+				Target:   target,
+				Threads:  16,
+				Conflict: test.conflict,
+				Order:    test.order,
+			})
+			checkTree(t, target, want)
+		})
+	}
+}
+
+// The pools that replaced chunkArray must cope with fewer folders and files
+// than threads.
+func TestFewerEntriesThanThreads(t *testing.T) {
+	const threads = 16
+	for _, n := range []int{0, 1, 3, threads} {
+		t.Run(fmt.Sprint(n), func(t *testing.T) {
+			dir := t.TempDir()
+			source := filepath.Join(dir, "source")
+			target := filepath.Join(dir, "target")
+			tree := map[string]string{}
+			for i := 0; i < n; i++ {
+				tree[fmt.Sprintf("d%02d/f", i)] = fmt.Sprint(i)
+			}
+			if err := os.Mkdir(source, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			writeTree(t, source, tree)
+
+			result := copyTree(t, Options{Source: source, Target: target, Threads: threads})
+			if result.Copied != uint64(n) || result.Folders != uint64(n+1) {
+				t.Errorf("copied %d files of %d folders, want %d of %d", result.Copied, result.Folders, n, n+1)
+			}
+			checkTree(t, target, tree)
+		})
+	}
+}
+
+// countingProgress counts the calls of a run to its Progress.
+type countingProgress struct {
+	mu                   sync.Mutex
+	files, bytes         uint64
+	increments, finishes int
+	added                int64
+}
+
+func (p *countingProgress) Start(files, bytes uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.files, p.bytes = files, bytes
+}
+
+func (p *countingProgress) Increment() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.increments++
+}
+
+func (p *countingProgress) Add(n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.added += n
+}
+
+func (p *countingProgress) Finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.finishes++
+}
+
+func TestEmptyFiles(t *testing.T) {
+	const n = 50
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source")
+	target := filepath.Join(dir, "target")
+	tree := map[string]string{}
+	for i := 0; i < n; i++ {
+		tree[fmt.Sprintf("d%d/f%02d", i%5, i)] = ""
+	}
+	writeTree(t, source, tree)
+
+	progress := &countingProgress{}
+	result := copyTree(t, Options{Source: source, Target: target, Threads: 4, Progress: progress})
+	if result.Files != n || result.Copied != n || result.Bytes != 0 || result.CopiedBytes != 0 {
+		t.Errorf("got %d files, %d copied, %d bytes, %d copied, want %d, %d and none", result.Files, result.Copied,
+			result.Bytes, result.CopiedBytes, n, n)
+	}
+	if progress.files != n || progress.bytes != 0 || progress.increments != n || progress.added != 0 || progress.finishes != 1 {
+		t.Errorf("progress got %+v, want %d files of no bytes, each incremented, and finished once", progress, n)
+	}
+	checkTree(t, target, tree)
+}
+
+func TestEmptyFolders(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source")
+	target := filepath.Join(dir, "target")
+	writeTree(t, source, map[string]string{"a/": "", "b/c/": "", "d/e/f/": "", "d/skipped.tmp": "skipped"})
+
+	progress := &countingProgress{}
+	result := copyTree(t, Options{Source: source, Target: target, Exclude: []string{"*.tmp"}, Progress: progress})
+	if result.Files != 0 || result.Folders != 7 || result.Excluded != 1 {
+		t.Errorf("got %d files, %d folders and %d excluded, want none, 7 and 1", result.Files, result.Folders, result.Excluded)
+	}
+	if progress.increments != 0 || progress.finishes != 1 {
+		t.Errorf("progress got %+v, want it finished once and nothing else", progress)
+	}
+	checkTree(t, target, map[string]string{"a/": "", "b/c/": "", "d/e/f/": ""})
+}
+
+// A single file ends like a folder when the run is stopped.
+func TestDrainedSingleFile(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, filepath.Join(dir, "source"), map[string]string{"file": "file"})
+	drain := make(chan struct{})
+	close(drain)
+
+	for _, source := range []string{"source", filepath.Join("source", "file")} {
+		_, err := Copy(context.Background(), Options{
+			Source: filepath.Join(dir, source),
+			Target: filepath.Join(dir, "target-"+filepath.Base(source)),
+			Drain:  drain,
+		})
+		if !errors.Is(err, ErrDrained) {
+			t.Errorf("%s: got %v, want %v", source, err, ErrDrained)
+		}
+	}
+}
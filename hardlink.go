@@ -0,0 +1,80 @@
+package gocp
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+)
+
+// errNoLinkTarget is returned by linkTarget.link when the first copy of the
+// inode failed, so there is nothing to link to.
+var errNoLinkTarget = errors.New("first copy of the inode failed")
+
+// inodeKey identifies a file across the paths linking to it.
+type inodeKey struct {
+	dev, ino uint64
+}
+
+// hardlinks tracks the source files with several links, or with the same
+// content under Dedup, so the first path of each is copied and the others
+// linked to that copy. They are told apart by their inodeKey or content
+// hash.
+type hardlinks struct {
+	mu   sync.Mutex
+	seen map[any]*linkTarget
+}
+
+// linkTarget is the copy of an inode the other paths are linked to. done is
+// closed once the copy finished, and path is then set if it succeeded.
+type linkTarget struct {
+	done chan struct{}
+	path string
+}
+
+// claim returns the linkTarget of key. first tells whether the caller is the
+// first to claim it, and so has to copy it and call finish.
+func (h *hardlinks) claim(key any) (target *linkTarget, first bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if target, ok := h.seen[key]; ok {
+		return target, false
+	}
+	if h.seen == nil {
+		h.seen = map[any]*linkTarget{}
+	}
+	target = &linkTarget{done: make(chan struct{})}
+	h.seen[key] = target
+	return target, true
+}
+
+// finish records the outcome of the copy to path and releases the paths
+// waiting to link to it.
+func (t *linkTarget) finish(path string, err error) {
+	if err == nil {
+		t.path = path
+	}
+	close(t.done)
+}
+
+// link waits for the first copy of the inode and links dst to it, under a
+// temporary name renamed into place.
+func (t *linkTarget) link(ctx context.Context, dst string) error {
+	select {
+	case <-t.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if t.path == "" {
+		return errNoLinkTarget
+	}
+
+	tmp := tempPath(dst)
+	if err := os.Link(t.path, tmp); err != nil {
+		return err
+	}
+	err := os.Rename(tmp, dst)
+	// renaming onto a link to the same file does nothing, leaving tmp
+	os.Remove(tmp)
+	return err
+}
@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd)
+
+package gocp
+
+import "os"
+
+// inodeOf is not implemented on this platform, so every file is copied.
+func inodeOf(info os.FileInfo) (inodeKey, bool) {
+	return inodeKey{}, false
+}
@@ -0,0 +1,17 @@
+//go:build linux || darwin || freebsd
+
+package gocp
+
+import (
+	"os"
+	"syscall"
+)
+
+// inodeOf returns the inode of info if it has more than one link.
+func inodeOf(info os.FileInfo) (inodeKey, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || stat.Nlink < 2 {
+		return inodeKey{}, false
+	}
+	return inodeKey{uint64(stat.Dev), uint64(stat.Ino)}, true
+}
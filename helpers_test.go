@@ -0,0 +1,84 @@
+package gocp
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTree creates the files of tree, by slash-separated path relative to
+// dir, with their content. A path ending in a slash is an empty folder.
+func writeTree(t testing.TB, dir string, tree map[string]string) {
+	t.Helper()
+	for name, content := range tree {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if name[len(name)-1] == '/' {
+			if err := os.MkdirAll(path, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readTree returns the files below dir like writeTree takes them.
+func readTree(t testing.TB, dir string) map[string]string {
+	t.Helper()
+	tree := map[string]string{}
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || path == dir {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			entries, err := os.ReadDir(path)
+			if err == nil && len(entries) == 0 {
+				tree[rel+"/"] = ""
+			}
+			return err
+		}
+		content, err := os.ReadFile(path)
+		tree[rel] = string(content)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+// checkTree fails t unless dir holds exactly the files of want.
+func checkTree(t testing.TB, dir string, want map[string]string) {
+	t.Helper()
+	got := readTree(t, dir)
+	for name, content := range want {
+		if got[name] != content {
+			t.Errorf("%s: got %q, want %q", name, got[name], content)
+		}
+		delete(got, name)
+	}
+	for name := range got {
+		t.Errorf("%s: unexpected in the target", name)
+	}
+}
+
+// copyTree runs Copy with opts and fails t on any error of the run.
+func copyTree(t testing.TB, opts Options) Result {
+	t.Helper()
+	result, err := Copy(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, err := range result.Errors {
+		t.Error(err)
+	}
+	return result
+}
@@ -0,0 +1,161 @@
+package gocp
+
+import (
+	"bufio"
+	"errors"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ignoreRule is one line of a gitignore-style ignore file.
+type ignoreRule struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// ignoreRules holds the ignore files read so far, keyed by the slash
+// separated path, relative to the source, of the folder holding them.
+type ignoreRules map[string][]ignoreRule
+
+// load reads the ignore files named names from dir of src, located at rel
+// in the source, so that they apply to that folder's subtree.
+func (rules ignoreRules) load(src sourceFS, dir, rel string, names []string) error {
+	rel = filepath.ToSlash(rel)
+	for _, name := range names {
+		file, err := src.Open(filepath.Join(dir, name))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			if rule, ok := parseIgnoreLine(scanner.Text()); ok {
+				rules[rel] = append(rules[rel], rule)
+			}
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ignored reports whether rel, a path relative to the source, is ignored by
+// the ignore files of its parent folders. As with git, files deeper in the
+// tree and later lines take precedence.
+func (rules ignoreRules) ignored(rel string, isDir bool) bool {
+	if len(rules) == 0 {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+
+	// collect the folders holding rel, from the root down
+	var bases []string
+	for dir := path.Dir(rel); ; dir = path.Dir(dir) {
+		bases = append(bases, dir)
+		if dir == "." {
+			break
+		}
+	}
+
+	ignored := false
+	for i := len(bases) - 1; i >= 0; i-- {
+		sub := rel
+		if bases[i] != "." {
+			sub = strings.TrimPrefix(rel, bases[i]+"/")
+		}
+		for _, rule := range rules[bases[i]] {
+			if rule.dirOnly && !isDir {
+				continue
+			}
+			if rule.re.MatchString(sub) {
+				ignored = !rule.negate
+			}
+		}
+	}
+	return ignored
+}
+
+// parseIgnoreLine turns a gitignore pattern into a rule. It returns false for
+// blank lines and comments.
+func parseIgnoreLine(line string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+
+	var rule ignoreRule
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return ignoreRule{}, false
+	}
+
+	// a pattern with a slash other than at the end is relative to the
+	// ignore file's folder, otherwise it matches at any depth.
+	prefix := "(?:.*/)?"
+	if strings.Contains(line, "/") {
+		prefix = ""
+		line = strings.TrimPrefix(line, "/")
+	}
+
+	re, err := regexp.Compile("^" + prefix + globToRegexp(line) + "$")
+	if err != nil {
+		return ignoreRule{}, false
+	}
+	rule.re = re
+	return rule, true
+}
+
+// globToRegexp translates a gitignore glob, including "**", to a regular
+// expression matching slash separated paths.
+func globToRegexp(glob string) string {
+	var re strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case strings.HasPrefix(glob[i:], "**/"):
+			re.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			re.WriteString(".*")
+			i++
+		case c == '*':
+			re.WriteString("[^/]*")
+		case c == '?':
+			re.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				re.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			re.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return re.String()
+}
@@ -0,0 +1,166 @@
+package gocp
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// ErrListSource is returned by Copy when List is set while the sources are
+// not a single folder.
+var ErrListSource = errors.New("List needs a single source folder.")
+
+// errNotListable is the error of a listed path that isn't below the source.
+var errNotListable = errors.New("path is not relative to the source")
+
+// lister is the state of a listSource run.
+type lister struct {
+	src     sourceFS
+	source  string
+	root    string
+	follow  bool
+	rep     *report
+	ignores ignoreRules
+
+	listed  map[string]bool // the relative paths listed or created so far
+	dirs    map[string]bool // whether the entries below each folder can be listed
+	folders []fileEntry
+	files   []fileEntry
+}
+
+// listSource stats the paths of list, relative to the folder source, and
+// the folders leading to them, and returns them like getFilesAndDir without
+// walking the rest of the source. The entries keep the order of list, with
+// every folder ahead of those below it. A listed folder is created, but
+// what it holds is only copied if listed as well.
+func listSource(ctx context.Context, src sourceFS, source string, info os.FileInfo, list []string, follow bool, rep *report) (uint64, uint64, []fileEntry, []fileEntry) {
+	l := &lister{
+		src:     src,
+		source:  source,
+		root:    rep.rootOf(source),
+		follow:  follow,
+		rep:     rep,
+		ignores: ignoreRules{},
+		listed:  map[string]bool{},
+		dirs:    map[string]bool{},
+	}
+	l.addFolder(".", source, info)
+
+	for _, name := range list {
+		if ctx.Err() != nil {
+			break
+		}
+		rel := filepath.Clean(filepath.FromSlash(name))
+		if !filepath.IsLocal(rel) {
+			rep.fail("listing", name, errNotListable)
+			continue
+		}
+		if l.listed[rel] {
+			continue
+		}
+		l.listed[rel] = true
+		if l.folder(filepath.Dir(rel)) {
+			l.add(rel)
+		}
+	}
+
+	var filesCount, totalSize uint64
+	for _, file := range l.files {
+		filesCount++
+		if file.info.Mode().IsRegular() {
+			totalSize += uint64(file.info.Size())
+		}
+	}
+	return filesCount, totalSize, l.folders, l.files
+}
+
+// folder lists the folder at rel and those leading to it, unless that was
+// done already, and reports whether the entries below it can be listed.
+func (l *lister) folder(rel string) bool {
+	if ok, done := l.dirs[rel]; done {
+		return ok
+	}
+	ok := l.folder(filepath.Dir(rel))
+	if ok {
+		path, info, found := l.entry(rel)
+		switch {
+		case !found:
+			ok = false
+		case info.IsDir():
+			l.listed[rel] = true
+			l.addFolder(rel, path, info)
+		}
+		// what is listed below a file fails on its own
+	}
+	l.dirs[rel] = ok
+	return ok
+}
+
+// add lists the listed entry at rel, a folder or a file.
+func (l *lister) add(rel string) {
+	path, info, found := l.entry(rel)
+	switch {
+	case !found:
+		l.dirs[rel] = false
+	case info.IsDir():
+		l.addFolder(rel, path, info)
+	case !l.rep.selects(rel, info):
+		l.rep.excluded.Add(1)
+	default:
+		l.files = append(l.files, fileEntry{path, info, l.root})
+	}
+}
+
+// addFolder lists the folder at rel and reads its ignore files.
+func (l *lister) addFolder(rel, path string, info os.FileInfo) {
+	l.dirs[rel] = true
+	l.folders = append(l.folders, fileEntry{path, info, l.root})
+	if len(l.rep.ignoreFiles) == 0 {
+		return
+	}
+	if err := l.ignores.load(l.src, path, rel, l.rep.ignoreFiles); err != nil {
+		l.rep.fail("reading ignore files in", path, err)
+	}
+}
+
+// entry stats the entry at rel as the walk would, and reports whether it is
+// to be copied as far as Exclude, the ignore files and the special files
+// go.
+func (l *lister) entry(rel string) (string, os.FileInfo, bool) {
+	rep := l.rep
+	path := filepath.Join(l.source, rel)
+
+	info, err := l.src.Lstat(path)
+	if err == nil && l.follow && info.Mode()&os.ModeSymlink != 0 {
+		info, err = l.src.Stat(path)
+	}
+	if err != nil {
+		if errors.Is(err, fs.ErrPermission) {
+			rep.skipUnreadable(path, err)
+		} else {
+			rep.fail("reading", path, err)
+		}
+		return path, nil, false
+	}
+
+	if rep.exclude.match(rel) || l.ignores.ignored(rel, info.IsDir()) {
+		rep.excluded.Add(1)
+		return path, nil, false
+	}
+	if isSpecial(info.Mode()) && (!rep.special || info.Mode()&os.ModeSocket != 0) {
+		rep.log.Info("Skipping special file", "path", path)
+		rep.specials.Add(1)
+		return path, nil, false
+	}
+	return path, info, true
+}
+
+// checkList makes sure the sources suit opts.List.
+func (opts *Options) checkList(infos []os.FileInfo) error {
+	if opts.List != nil && (len(infos) != 1 || !infos[0].IsDir()) {
+		return ErrListSource
+	}
+	return nil
+}
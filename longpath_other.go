@@ -0,0 +1,9 @@
+//go:build !windows
+
+package gocp
+
+// longPath returns path unchanged where paths have no length limit of their
+// own.
+func longPath(path string) string {
+	return path
+}
@@ -0,0 +1,26 @@
+package gocp
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// longPathPrefix opts a path out of the MAX_PATH limit of 260 characters.
+const longPathPrefix = `\\?\`
+
+// longPath returns path in its extended-length form, made absolute first
+// since the prefix disables the resolution of relative paths, "." and "..".
+func longPath(path string) string {
+	if strings.HasPrefix(path, longPathPrefix) {
+		return path
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		// a share \\server\share becomes \\?\UNC\server\share
+		return longPathPrefix + `UNC\` + abs[2:]
+	}
+	return longPathPrefix + abs
+}
@@ -0,0 +1,53 @@
+package gocp
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLongPath(t *testing.T) {
+	long := strings.Repeat(`folder\`, 40) + "file.txt"
+	tests := []struct {
+		path, want string
+	}{
+		{`C:\` + long, `\\?\C:\` + long},
+		{`\\server\share\` + long, `\\?\UNC\server\share\` + long},
+		{`\\?\C:\` + long, `\\?\C:\` + long},
+		{`C:\data\..\` + long, `\\?\C:\` + long},
+	}
+	for _, test := range tests {
+		if got := longPath(test.path); got != test.want {
+			t.Errorf("longPath(%q) = %q, want %q", test.path, got, test.want)
+		}
+	}
+}
+
+func TestCopyLongPath(t *testing.T) {
+	dir := t.TempDir()
+	name := strings.Repeat("a", 50)
+	rel := strings.Repeat(name+"/", 5) + "file.txt"
+	source := filepath.Join(dir, "source")
+	target := filepath.Join(dir, "target")
+	if len(filepath.Join(source, rel)) <= 260 {
+		t.Fatalf("path of %d characters isn't over MAX_PATH", len(filepath.Join(source, rel)))
+	}
+	// writeTree can't create it without the prefix
+	path := longPath(filepath.Join(source, filepath.FromSlash(rel)))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("long"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	copyTree(t, Options{Source: source, Target: target})
+	content, err := os.ReadFile(longPath(filepath.Join(target, filepath.FromSlash(rel))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "long" {
+		t.Errorf("got %q, want %q", content, "long")
+	}
+}
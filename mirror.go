@@ -0,0 +1,68 @@
+package gocp
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+)
+
+// mirrorTarget deletes the files and folders of the target that have no
+// counterpart in any of sources, leaving alone those matching Exclude.
+func mirrorTarget(ctx context.Context, opts *Options, sources []string, rep *report) {
+	// a source that is a file accounts for its name in the target only
+	var folders []string
+	files := map[string]bool{}
+	for _, source := range sources {
+		if info, err := rep.src.Stat(source); err == nil && !info.IsDir() {
+			files[opts.normalize(filepath.Base(source))] = true
+		} else {
+			folders = append(folders, source)
+		}
+	}
+
+	err := filepath.WalkDir(opts.Target, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		relativePath, _ := filepath.Rel(opts.Target, path)
+		if relativePath == "." {
+			return nil
+		}
+		if rep.exclude.match(relativePath) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// a file may have been renamed by Compress or Decompress
+		for _, name := range opts.sourceNames(relativePath) {
+			if files[name] {
+				return nil
+			}
+			for _, source := range folders {
+				if rel, ok := opts.sourcePath(source, name); ok && opts.sourceExists(rep.src, source, rel) {
+					return nil
+				}
+			}
+		}
+
+		if opts.DryRun {
+			opts.Logger.Info("Would delete", "path", path)
+		} else if err := os.RemoveAll(path); err != nil {
+			rep.fail("deleting", path, err)
+			return nil
+		}
+		rep.deleted.Add(1)
+		if d.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil && ctx.Err() == nil && !os.IsNotExist(err) {
+		rep.fail("deleting extraneous files in", opts.Target, err)
+	}
+}
@@ -0,0 +1,37 @@
+package gocp
+
+import "os"
+
+// filePerm returns the permission bits of the copy of a file of mode mode,
+// and whether they are to be set explicitly rather than left to the umask.
+func (opts *Options) filePerm(mode os.FileMode) (os.FileMode, bool) {
+	switch {
+	case opts.FileMode != 0:
+		return opts.FileMode.Perm(), true
+	case opts.Preserve:
+		return mode.Perm(), true
+	}
+	return 0666, false
+}
+
+// dirPerm is filePerm for folders.
+func (opts *Options) dirPerm(mode os.FileMode) (os.FileMode, bool) {
+	switch {
+	case opts.DirMode != 0:
+		return opts.DirMode.Perm(), true
+	case opts.Preserve:
+		return mode.Perm(), true
+	}
+	return os.ModePerm, false
+}
+
+// rootPerm is dirPerm for the target folder when Copy creates it: it stands
+// for the source folder when there is a single one, and for none otherwise.
+// Without a mode to give it, it is left as MkdirAll makes it, the default
+// less the umask, as mkdir does.
+func (opts *Options) rootPerm(sources []string, infos []os.FileInfo) (os.FileMode, bool) {
+	if len(sources) == 1 && !opts.keepsRoot(sources[0]) {
+		return opts.dirPerm(infos[0].Mode())
+	}
+	return opts.DirMode.Perm(), opts.DirMode != 0
+}
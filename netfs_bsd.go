@@ -0,0 +1,23 @@
+//go:build darwin || freebsd
+
+package gocp
+
+import "golang.org/x/sys/unix"
+
+// networkTypes are the names of the network file systems.
+var networkTypes = map[string]bool{
+	"nfs":    true,
+	"smbfs":  true,
+	"afpfs":  true,
+	"webdav": true,
+	"cifs":   true,
+}
+
+// networkFS reports whether path is on a network file system.
+func networkFS(path string) (bool, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return false, err
+	}
+	return networkTypes[unix.ByteSliceToString(stat.Fstypename[:])], nil
+}
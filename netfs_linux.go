@@ -0,0 +1,27 @@
+package gocp
+
+import "golang.org/x/sys/unix"
+
+// networkMagics are the statfs types of the network file systems.
+var networkMagics = map[uint32]bool{
+	0x6969:     true, // NFS
+	0x517b:     true, // SMB
+	0xff534d42: true, // CIFS
+	0xfe534d42: true, // SMB2
+	0x564c:     true, // NCP
+	0x00c36400: true, // Ceph
+	0x6b414653: true, // AFS
+	0x5346414f: true, // OpenAFS
+	0x73757245: true, // Coda
+	0x47504653: true, // GPFS
+	0x0bd00bd0: true, // Lustre
+}
+
+// networkFS reports whether path is on a network file system.
+func networkFS(path string) (bool, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return false, err
+	}
+	return networkMagics[uint32(stat.Type)], nil
+}
@@ -0,0 +1,9 @@
+//go:build !(linux || darwin || freebsd || windows)
+
+package gocp
+
+// networkFS is not implemented on this platform, so nothing is taken for a
+// network file system.
+func networkFS(path string) (bool, error) {
+	return false, nil
+}
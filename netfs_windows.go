@@ -0,0 +1,21 @@
+package gocp
+
+import (
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/windows"
+)
+
+// networkFS reports whether path is on a shared folder or a mapped network
+// drive.
+func networkFS(path string) (bool, error) {
+	if _, err := os.Stat(path); err != nil {
+		return false, err
+	}
+	root, err := windows.UTF16PtrFromString(filepath.VolumeName(path) + `\`)
+	if err != nil {
+		return false, err
+	}
+	return windows.GetDriveType(root) == windows.DRIVE_REMOTE, nil
+}
@@ -0,0 +1,75 @@
+package gocp
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Normalization is the Unicode normal form names take in the target.
+type Normalization int
+
+const (
+	// NormalizeNone keeps names as they are in the source.
+	NormalizeNone Normalization = iota
+	// NormalizeNFC composes names, as Linux and Windows usually have them.
+	NormalizeNFC
+	// NormalizeNFD decomposes names, as macOS stores them on HFS+.
+	NormalizeNFD
+)
+
+// normalize returns name in the normal form of opts.Normalize.
+func (opts *Options) normalize(name string) string {
+	switch opts.Normalize {
+	case NormalizeNFC:
+		return norm.NFC.String(name)
+	case NormalizeNFD:
+		return norm.NFD.String(name)
+	}
+	return name
+}
+
+// targetPath returns where the entry at relativePath in a source goes in
+// the target.
+func (opts *Options) targetPath(relativePath string) string {
+	return filepath.Join(opts.Target, opts.normalize(relativePath))
+}
+
+// sourceExists reports whether source, read through src, holds an entry at
+// relativePath, a path of the target, which Normalize may have changed on
+// the way.
+func (opts *Options) sourceExists(src sourceFS, source, relativePath string) bool {
+	if _, err := src.Lstat(filepath.Join(source, relativePath)); !errors.Is(err, fs.ErrNotExist) {
+		return true
+	}
+	if opts.Normalize == NormalizeNone {
+		return false
+	}
+
+	// look for each part of the path among the names of its folder
+	dir := source
+	for _, part := range strings.Split(relativePath, string(filepath.Separator)) {
+		if _, err := src.Lstat(filepath.Join(dir, part)); err == nil {
+			dir = filepath.Join(dir, part)
+			continue
+		}
+		entries, err := src.ReadDir(dir)
+		if err != nil {
+			return false
+		}
+		found := false
+		for _, entry := range entries {
+			if opts.normalize(entry.Name()) == part {
+				dir, found = filepath.Join(dir, entry.Name()), true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
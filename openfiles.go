@@ -0,0 +1,71 @@
+package gocp
+
+import (
+	"context"
+	"sync"
+)
+
+// filesPerCopy is how many files a copy holds open at once: its source and
+// its target.
+const filesPerCopy = 2
+
+// openFilesReserve is left of the open file limit, or half of it if less,
+// for everything but the copies, such as the walks and the logs.
+const openFilesReserve = 64
+
+// fileLimit caps the files the workers hold open at once, so a high thread
+// count doesn't run into the open file limit of the process.
+type fileLimit struct {
+	mu    sync.Mutex // lets one worker at a time take its slots
+	slots chan struct{}
+}
+
+// newFileLimit returns a limit of n files, or nil, which doesn't limit
+// anything, when n is 0.
+func newFileLimit(n int) *fileLimit {
+	if n <= 0 {
+		return nil
+	}
+	return &fileLimit{slots: make(chan struct{}, n)}
+}
+
+// defaultFileLimit returns a limit below the soft open file limit of the
+// process, or 0 where there is none.
+func defaultFileLimit() int {
+	limit := openFileLimit()
+	if limit == 0 {
+		return 0
+	}
+	return max(limit-min(openFilesReserve, limit/2), filesPerCopy)
+}
+
+// acquire waits until n more files may be opened, or ctx is done. The slots
+// are taken one worker at a time, so two workers never hold half of what
+// they need each.
+func (l *fileLimit) acquire(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+	n = min(n, cap(l.slots))
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i := 0; i < n; i++ {
+		select {
+		case l.slots <- struct{}{}:
+		case <-ctx.Done():
+			l.release(i)
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// release gives back n files taken by acquire.
+func (l *fileLimit) release(n int) {
+	if l == nil {
+		return
+	}
+	for i := 0; i < min(n, cap(l.slots)); i++ {
+		<-l.slots
+	}
+}
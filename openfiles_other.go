@@ -0,0 +1,9 @@
+//go:build !(linux || darwin || freebsd)
+
+package gocp
+
+// openFileLimit is not implemented on this platform, so the open files are
+// not limited by default.
+func openFileLimit() int {
+	return 0
+}
@@ -0,0 +1,14 @@
+//go:build linux || darwin || freebsd
+
+package gocp
+
+import "golang.org/x/sys/unix"
+
+// openFileLimit returns the soft RLIMIT_NOFILE of the process.
+func openFileLimit() int {
+	var limit unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_NOFILE, &limit); err != nil || limit.Cur > 1<<30 {
+		return 0
+	}
+	return int(limit.Cur)
+}
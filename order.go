@@ -0,0 +1,59 @@
+package gocp
+
+import (
+	"path/filepath"
+	"sort"
+)
+
+// Order is the order files are handed to the copy workers in.
+type Order int
+
+const (
+	// OrderScan keeps the order of the scan: each folder's entries by name,
+	// a subfolder's contents before the entries that follow it.
+	OrderScan Order = iota
+	// OrderName sorts the files by their path relative to their source.
+	OrderName
+	// OrderSize sorts the files from the smallest to the largest, and by
+	// OrderName among those of the same size.
+	OrderSize
+)
+
+// sortFiles puts files in the order of opts.Order, along with their
+// targets unless they are nil.
+func (opts *Options) sortFiles(files []fileEntry, targets []string) {
+	if opts.Order == OrderScan {
+		return
+	}
+	rel := make([]string, len(files))
+	for i, file := range files {
+		rel[i], _ = filepath.Rel(file.root, file.path)
+	}
+	sort.Stable(fileOrder{opts.Order, files, rel, targets})
+}
+
+// fileOrder sorts files by order, swapping their relative paths and
+// targets with them.
+type fileOrder struct {
+	order   Order
+	files   []fileEntry
+	rel     []string
+	targets []string
+}
+
+func (o fileOrder) Len() int { return len(o.files) }
+
+func (o fileOrder) Less(i, j int) bool {
+	if o.order == OrderSize && o.files[i].info.Size() != o.files[j].info.Size() {
+		return o.files[i].info.Size() < o.files[j].info.Size()
+	}
+	return o.rel[i] < o.rel[j]
+}
+
+func (o fileOrder) Swap(i, j int) {
+	o.files[i], o.files[j] = o.files[j], o.files[i]
+	o.rel[i], o.rel[j] = o.rel[j], o.rel[i]
+	if o.targets != nil {
+		o.targets[i], o.targets[j] = o.targets[j], o.targets[i]
+	}
+}
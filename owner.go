@@ -0,0 +1,21 @@
+package gocp
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+)
+
+// preserveOwner gives path the owner and group of info. Lacking the privilege
+// to do so is reported once to log as a warning instead of failing the copy.
+func (r *report) preserveOwner(log *slog.Logger, path string, info os.FileInfo, link bool) error {
+	err := chown(path, info, link)
+	if errors.Is(err, fs.ErrPermission) {
+		r.ownerWarning.Do(func() {
+			log.Warn("Cannot preserve owners, running without sufficient privileges", "error", err)
+		})
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package gocp
+
+import "os"
+
+// chown is a no-op where files have no Unix owner.
+func chown(path string, info os.FileInfo, link bool) error {
+	return nil
+}
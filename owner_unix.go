@@ -0,0 +1,21 @@
+//go:build unix
+
+package gocp
+
+import (
+	"os"
+	"syscall"
+)
+
+// chown gives path the uid and gid recorded in info, changing the link itself
+// rather than its target when link is set.
+func chown(path string, info os.FileInfo, link bool) error {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	if link {
+		return os.Lchown(path, int(stat.Uid), int(stat.Gid))
+	}
+	return os.Chown(path, int(stat.Uid), int(stat.Gid))
+}
@@ -0,0 +1,80 @@
+package gocp
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+)
+
+type ThreadPool struct {
+	tasks chan poolTask
+	wg    sync.WaitGroup
+}
+
+// poolTask is a submitted task and the handler for its panics.
+type poolTask struct {
+	run     func()
+	onPanic func(*PanicError)
+}
+
+// PanicError is a panic recovered from a task.
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// NewThreadPool creates a new thread pool with a specified number of workers,
+// sharing a task queue that holds up to one pending task per worker.
+func NewThreadPool(numWorkers int) *ThreadPool {
+	pool := &ThreadPool{
+		tasks: make(chan poolTask, numWorkers),
+	}
+	for i := 0; i < numWorkers; i++ {
+		pool.wg.Add(1)
+		go func() {
+			defer pool.wg.Done()
+			for task := range pool.tasks {
+				task.execute()
+			}
+		}()
+	}
+	return pool
+}
+
+// Submit queues a task for the thread pool, blocking while the queue is full.
+// It returns ctx.Err() if ctx is done first. If the task panics, its worker
+// recovers and moves on to the next task, passing the panic to onPanic
+// unless it is nil.
+func (pool *ThreadPool) Submit(ctx context.Context, task func(), onPanic func(*PanicError)) error {
+	select {
+	case pool.tasks <- poolTask{task, onPanic}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stop stops the thread pool, waiting for all tasks to complete.
+func (pool *ThreadPool) Stop() {
+	close(pool.tasks)
+	pool.wg.Wait()
+}
+
+func (pool *ThreadPool) Wait() {
+	pool.wg.Wait()
+}
+
+// execute runs the task, recovering from a panic so the worker survives it.
+func (task poolTask) execute() {
+	defer func() {
+		if v := recover(); v != nil && task.onPanic != nil {
+			task.onPanic(&PanicError{Value: v, Stack: debug.Stack()})
+		}
+	}()
+	task.run()
+}
@@ -0,0 +1,107 @@
+package gocp
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestThreadPoolRunsAllTasks(t *testing.T) {
+	const tasks = 1000
+	pool := NewThreadPool(8)
+	var ran atomic.Int64
+	for i := 0; i < tasks; i++ {
+		if err := pool.Submit(context.Background(), func() { ran.Add(1) }, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pool.Stop()
+	if n := ran.Load(); n != tasks {
+		t.Errorf("ran %d tasks, want %d", n, tasks)
+	}
+}
+
+func TestThreadPoolRunsOnWorkers(t *testing.T) {
+	const workers = 8
+	pool := NewThreadPool(workers)
+	var started sync.WaitGroup
+	started.Add(workers)
+	release := make(chan struct{})
+	submitted := make(chan struct{})
+	go func() {
+		// a task run inline would block here on release
+		for i := 0; i < workers; i++ {
+			pool.Submit(context.Background(), func() {
+				started.Done()
+				<-release
+			}, nil)
+		}
+		close(submitted)
+	}()
+
+	running := make(chan struct{})
+	go func() {
+		started.Wait()
+		close(running)
+	}()
+	select {
+	case <-running:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the tasks didn't all run at once on the workers")
+	}
+	select {
+	case <-submitted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Submit didn't return while the tasks ran")
+	}
+	close(release)
+	pool.Stop()
+}
+
+func TestThreadPoolStopWaits(t *testing.T) {
+	pool := NewThreadPool(2)
+	var done atomic.Bool
+	pool.Submit(context.Background(), func() {
+		time.Sleep(50 * time.Millisecond)
+		done.Store(true)
+	}, nil)
+	pool.Stop()
+	if !done.Load() {
+		t.Error("Stop returned before the task finished")
+	}
+}
+
+func TestThreadPoolPanic(t *testing.T) {
+	pool := NewThreadPool(1)
+	var panicked *PanicError
+	var after atomic.Bool
+	pool.Submit(context.Background(), func() { panic("boom") }, func(p *PanicError) { panicked = p })
+	// the worker survives to run the next task
+	pool.Submit(context.Background(), func() { after.Store(true) }, nil)
+	pool.Stop()
+	if panicked == nil || panicked.Value != "boom" || len(panicked.Stack) == 0 {
+		t.Errorf("got %#v, want the panic with its stack", panicked)
+	}
+	if !after.Load() {
+		t.Error("the task after the panic didn't run")
+	}
+}
+
+func TestThreadPoolSubmitCanceled(t *testing.T) {
+	pool := NewThreadPool(1)
+	release := make(chan struct{})
+	// one task running and one queued fill the pool
+	pool.Submit(context.Background(), func() { <-release }, nil)
+	pool.Submit(context.Background(), func() {}, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := pool.Submit(ctx, func() {}, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	close(release)
+	pool.Stop()
+}
@@ -0,0 +1,18 @@
+package gocp
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// preallocate reserves size bytes for file without changing its size. It is
+// best effort: file systems without F_PREALLOCATE are left as they are.
+func preallocate(file *os.File, size int64) {
+	fstore := unix.Fstore_t{
+		Flags:   unix.F_ALLOCATEALL,
+		Posmode: unix.F_PEOFPOSMODE,
+		Length:  size,
+	}
+	unix.FcntlFstore(file.Fd(), unix.F_PREALLOCATE, &fstore)
+}
@@ -0,0 +1,13 @@
+package gocp
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// preallocate reserves size bytes for file without changing its size. It is
+// best effort: file systems without fallocate are left as they are.
+func preallocate(file *os.File, size int64) {
+	unix.Fallocate(int(file.Fd()), unix.FALLOC_FL_KEEP_SIZE, 0, size)
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package gocp
+
+import "os"
+
+// preallocate is a no-op on this platform.
+func preallocate(file *os.File, size int64) {}
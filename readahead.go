@@ -0,0 +1,98 @@
+package gocp
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path/filepath"
+)
+
+// copyReadAhead copies r to w like io.CopyBuffer, but fills up to ahead
+// more buffers of size bytes from r while w writes the current one, so a
+// file's reads and writes overlap instead of taking turns. The buffers are
+// filled completely, which keeps the writes few and large.
+func copyReadAhead(w io.Writer, r io.Reader, size, ahead int) (int64, error) {
+	type chunk struct {
+		buf *[]byte
+		n   int
+		err error
+	}
+	free := make(chan *[]byte, ahead+1)
+	for i := 0; i <= ahead; i++ {
+		free <- getBuffer(size)
+	}
+	// each buffer is in one channel or the other, so neither blocks a send
+	full := make(chan chunk, ahead+1)
+	stop := make(chan struct{})
+	go func() {
+		defer close(full)
+		for {
+			var buf *[]byte
+			select {
+			case buf = <-free:
+			case <-stop:
+				return
+			}
+			n, err := io.ReadFull(r, *buf)
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				err = io.EOF
+			}
+			full <- chunk{buf, n, err}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	var written int64
+	var err error
+	for c := range full {
+		if c.n > 0 {
+			var n int
+			n, err = w.Write((*c.buf)[:c.n])
+			written += int64(n)
+			if err == nil && n < c.n {
+				err = io.ErrShortWrite
+			}
+		}
+		if err == nil && c.err != io.EOF {
+			err = c.err
+		}
+		free <- c.buf
+		if err != nil {
+			break
+		}
+	}
+
+	// the buffers go back to the pool once the reader is done with them
+	close(stop)
+	for c := range full {
+		free <- c.buf
+	}
+	for i := 0; i <= ahead; i++ {
+		bufferPool.Put(<-free)
+	}
+	return written, err
+}
+
+// OnNetworkFS reports whether path, or the closest of its parents that
+// exists, is on a network file system such as NFS or SMB, where each call
+// waits on a round trip and a larger BufferSize and ReadAhead pay off. It
+// is false where the platform doesn't tell.
+func OnNetworkFS(path string) bool {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	for {
+		remote, err := networkFS(path)
+		if !errors.Is(err, fs.ErrNotExist) {
+			return remote
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return false
+		}
+		path = parent
+	}
+}
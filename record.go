@@ -0,0 +1,56 @@
+package gocp
+
+import "time"
+
+// FileLog receives a record of what happened to every file of a run, except
+// in a dry run. Record is called from multiple workers and must be safe for
+// concurrent use.
+type FileLog interface {
+	Record(FileRecord)
+}
+
+// FileRecord is the outcome of a single file.
+type FileRecord struct {
+	Source   string
+	Target   string // empty for a file left out of a flattened or normalized target
+	Bytes    int64  // size of the source file
+	Duration time.Duration
+	Result   FileResult
+	Err      error // why the file failed, for ResultFailed
+	// Checksum is the SHA-256 of the target file once copied or linked,
+	// with Options.Checksums.
+	Checksum []byte
+}
+
+// FileResult is what became of a file.
+type FileResult int
+
+const (
+	ResultCopied   FileResult = iota // copied to the target
+	ResultVerified                   // copied and found the same by Verify
+	ResultLinked                     // linked to an earlier copy by Hardlinks or Dedup
+	ResultSkipped                    // left alone by Update or Conflict
+	ResultFailed                     // not copied because of Err
+)
+
+var fileResultNames = [...]string{"copied", "verified", "linked", "skipped", "failed"}
+
+func (r FileResult) String() string {
+	return fileResultNames[r]
+}
+
+// record passes the outcome of file to opts.FileLog, if any.
+func record(opts *Options, file fileEntry, destFile string, start time.Time, result FileResult, err error, sum []byte) {
+	if opts.FileLog == nil || opts.DryRun {
+		return
+	}
+	opts.FileLog.Record(FileRecord{
+		Source:   file.path,
+		Target:   destFile,
+		Bytes:    file.info.Size(),
+		Duration: time.Since(start),
+		Result:   result,
+		Err:      err,
+		Checksum: sum,
+	})
+}
@@ -0,0 +1,118 @@
+package gocp
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// manifest lists the target files a run has completed, in the file of
+// Options.Manifest, so a later run can skip them. Each line holds the size
+// and modification time of a target file after its copy, then its path.
+// Lines are appended as files finish, so a crash loses at most the line
+// being written, which is ignored when read back.
+type manifest struct {
+	path string
+	done map[string]manifestEntry // read only once the run starts
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+// manifestEntry is the state a target file was left in by its copy.
+type manifestEntry struct {
+	size    int64
+	modTime time.Time
+}
+
+// openManifest reads the manifest at path, if there is one, and opens it to
+// append to unless dryRun is set.
+func openManifest(path string, dryRun bool) (*manifest, error) {
+	m := &manifest{path: path, done: map[string]manifestEntry{}}
+	file, err := os.Open(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("Cannot read resume manifest: %w", err)
+	}
+	if err == nil {
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			if dst, entry, ok := parseManifestLine(scanner.Text()); ok {
+				m.done[dst] = entry
+			}
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("Cannot read resume manifest: %w", err)
+		}
+	}
+
+	if !dryRun {
+		if m.file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644); err != nil {
+			return nil, fmt.Errorf("Cannot write resume manifest: %w", err)
+		}
+	}
+	return m, nil
+}
+
+// parseManifestLine splits a line of the manifest into its target path and
+// entry.
+func parseManifestLine(line string) (string, manifestEntry, bool) {
+	fields := strings.SplitN(line, "\t", 3)
+	if len(fields) != 3 || fields[2] == "" {
+		return "", manifestEntry{}, false
+	}
+	size, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return "", manifestEntry{}, false
+	}
+	nanos, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return "", manifestEntry{}, false
+	}
+	return fields[2], manifestEntry{size, time.Unix(0, nanos)}, true
+}
+
+// completed reports whether dst was copied by an earlier run, is still as
+// that run left it, and the source file described by info hasn't been
+// modified since.
+func (m *manifest) completed(dst string, info os.FileInfo) bool {
+	entry, ok := m.done[dst]
+	if !ok || info.ModTime().After(entry.modTime) {
+		return false
+	}
+	dstInfo, err := os.Lstat(dst)
+	return err == nil && dstInfo.Size() == entry.size && dstInfo.ModTime().Equal(entry.modTime)
+}
+
+// add appends dst, just copied, to the manifest.
+func (m *manifest) add(dst string) error {
+	info, err := os.Lstat(dst)
+	if err != nil {
+		return err
+	}
+	line := fmt.Sprintf("%d\t%d\t%s\n", info.Size(), info.ModTime().UnixNano(), dst)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err = m.file.WriteString(line)
+	return err
+}
+
+// close closes the manifest, deleting it once the run is complete since
+// there is nothing left to resume.
+func (m *manifest) close(complete bool) error {
+	if m.file == nil {
+		return nil
+	}
+	if err := m.file.Close(); err != nil {
+		return err
+	}
+	if complete {
+		return os.Remove(m.path)
+	}
+	return nil
+}
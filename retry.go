@@ -0,0 +1,73 @@
+package gocp
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// retryDelay is the wait before the first retry; it doubles with each one.
+const retryDelay = 100 * time.Millisecond
+
+// copyWithRetries runs copy with opts, retrying up to opts.Retries times
+// with exponential backoff while it fails with an error that may be
+// transient.
+func copyWithRetries(ctx context.Context, opts *Options, rep *report, copy func(*Options) error) error {
+	if opts.Retries <= 0 {
+		return copy(opts)
+	}
+
+	// count what each attempt reports, to take it back when it fails
+	progress := &attemptProgress{Progress: opts.Progress}
+	attempt := *opts
+	attempt.Progress = progress
+
+	for i := 0; ; i++ {
+		progress.n.Store(0)
+		err := copy(&attempt)
+		if err == nil || i >= opts.Retries || !isTransient(err) || ctx.Err() != nil {
+			return err
+		}
+		opts.Progress.Add(-progress.n.Load())
+		if i == 0 {
+			rep.retried.Add(1)
+		}
+
+		timer := time.NewTimer(retryDelay << i)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+	}
+}
+
+// isTransient reports whether err may go away when the copy is retried, as
+// opposed to errors such as a missing source that retrying can't fix.
+func isTransient(err error) bool {
+	for _, permanent := range []error{
+		fs.ErrNotExist, fs.ErrPermission, fs.ErrExist, errCloneUnsupported,
+		syscall.EISDIR, syscall.ENOTDIR, syscall.ENOSPC, syscall.EROFS, syscall.ENAMETOOLONG,
+	} {
+		if errors.Is(err, permanent) {
+			return false
+		}
+	}
+	return !errors.Is(err, context.Canceled)
+}
+
+// attemptProgress counts the bytes reported during one attempt of a copy,
+// which the ranges of a split file report at once.
+type attemptProgress struct {
+	Progress
+	n atomic.Int64
+}
+
+func (p *attemptProgress) Add(n int64) {
+	p.n.Add(n)
+	p.Progress.Add(n)
+}
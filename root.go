@@ -0,0 +1,71 @@
+package gocp
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrRootName is returned when Options.RootName is not a plain file name.
+var ErrRootName = errors.New("RootName must be a single file name.")
+
+// keepsRoot reports whether the folder source is copied into a folder of
+// its own name, following KeepRoot, or TrailingSlash if it is set.
+func (opts *Options) keepsRoot(source string) bool {
+	if opts.RootName != "" {
+		return false
+	}
+	if opts.TrailingSlash && opts.SourceFS == nil {
+		// "." and ".." have no name of their own, as with rsync
+		base := filepath.Base(source)
+		return !os.IsPathSeparator(source[len(source)-1]) && base != "." && base != ".."
+	}
+	// a root has no name to keep, however it is written
+	clean := filepath.Clean(source)
+	return opts.KeepRoot && !(filepath.IsAbs(clean) && filepath.Dir(clean) == clean)
+}
+
+// checkRootName makes sure RootName names a single folder.
+func (opts *Options) checkRootName() error {
+	name := opts.RootName
+	if name != "" && (name == "." || name == ".." || strings.ContainsAny(name, `/\`)) {
+		return ErrRootName
+	}
+	return nil
+}
+
+// applyRootName checks RootName and moves Target into it.
+func (opts *Options) applyRootName() error {
+	if err := opts.checkRootName(); err != nil {
+		return err
+	}
+	opts.Target = filepath.Join(opts.Target, opts.RootName)
+	return nil
+}
+
+// rootOf returns the folder the entries of the folder source are named
+// relative to: its parent with KeepRoot, so its own name leads their paths,
+// and the source itself otherwise.
+func (r *report) rootOf(source string) string {
+	if r.keepsRoot(source) {
+		// "src/" is named src as well
+		return filepath.Dir(filepath.Clean(source))
+	}
+	return source
+}
+
+// sourcePath returns where relativePath, a path of the target, lies in the
+// folder source, which is only below it with KeepRoot if it starts with the
+// name of the source.
+func (opts *Options) sourcePath(source, relativePath string) (string, bool) {
+	if !opts.keepsRoot(source) {
+		return relativePath, true
+	}
+	root := opts.normalize(filepath.Base(filepath.Clean(source)))
+	if relativePath == root {
+		return ".", true
+	}
+	rest, ok := strings.CutPrefix(relativePath, root+string(filepath.Separator))
+	return rest, ok
+}
@@ -0,0 +1,61 @@
+package gocp
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestKeepRootMirror(t *testing.T) {
+	for _, suffix := range []string{"", string(filepath.Separator)} {
+		t.Run("src"+suffix, func(t *testing.T) {
+			dir := t.TempDir()
+			source := filepath.Join(dir, "src")
+			target := filepath.Join(dir, "target")
+			writeTree(t, source, map[string]string{"a/f": "f", "g": "g"})
+			writeTree(t, target, map[string]string{"src/stale": "stale", "other": "other"})
+
+			copyTree(t, Options{Source: source + suffix, Target: target, KeepRoot: true, Mirror: true})
+			checkTree(t, target, map[string]string{"src/a/f": "f", "src/g": "g"})
+		})
+	}
+}
+
+func TestKeepRootOfRoot(t *testing.T) {
+	root := filepath.VolumeName(os.TempDir()) + string(filepath.Separator)
+	opts := Options{KeepRoot: true}
+	if opts.keepsRoot(root) {
+		t.Errorf("keepsRoot(%q) = true, want false", root)
+	}
+}
+
+func TestKeepsRootTrailingSlash(t *testing.T) {
+	windows := runtime.GOOS == "windows"
+	tests := []struct {
+		source string
+		want   bool
+	}{
+		{"src", true},
+		{"src/", false},
+		{`src\`, !windows},
+		{filepath.Join("parent", "src"), true},
+		{filepath.Join("parent", "src") + string(filepath.Separator), false},
+		{".", false},
+		{"..", false},
+		{"./", false},
+		{filepath.Join("src", ".."), false},
+	}
+	opts := Options{TrailingSlash: true}
+	for _, test := range tests {
+		if got := opts.keepsRoot(test.source); got != test.want {
+			t.Errorf("keepsRoot(%q) = %v, want %v", test.source, got, test.want)
+		}
+	}
+
+	// RootName names the root instead
+	opts.RootName = "named"
+	if opts.keepsRoot("src") {
+		t.Error("keepsRoot with RootName = true, want false")
+	}
+}
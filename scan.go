@@ -0,0 +1,430 @@
+package gocp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var errLinkLoop = errors.New("symbolic link loop")
+
+// statSources returns the FileInfo of each source. It fails with
+// ErrSourceNotFound if one of them doesn't exist and with ErrSourceNotDir if
+// there are none or one is neither a folder nor a regular file, such as a
+// pipe or a device.
+func statSources(src sourceFS, sources []string) ([]os.FileInfo, error) {
+	if len(sources) == 0 {
+		return nil, ErrSourceNotDir
+	}
+	infos := make([]os.FileInfo, len(sources))
+	for i, source := range sources {
+		info, err := src.Stat(source)
+		switch {
+		case os.IsNotExist(err):
+			return nil, fmt.Errorf("%s: %w", source, ErrSourceNotFound)
+		case err != nil:
+			return nil, fmt.Errorf("Cannot read source: %w", err)
+		case !info.IsDir() && !info.Mode().IsRegular():
+			return nil, fmt.Errorf("%s: %w", source, ErrSourceNotDir)
+		}
+		infos[i] = info
+	}
+	return infos, nil
+}
+
+// checkExists makes sure the target folder of opts exists, for NoCreate:
+// Target itself, before RootName is added, or the folder a single file is
+// copied into under a new name.
+func (opts *Options) checkExists(dst TargetFS, single bool) error {
+	target := opts.Target
+	if opts.RootName != "" {
+		target = filepath.Dir(target)
+	}
+	_, err := dst.Stat(target)
+	if !errors.Is(err, fs.ErrNotExist) {
+		// anything else is for checkTarget to tell
+		return nil
+	}
+	if single && opts.RootName == "" {
+		if info, err := dst.Stat(filepath.Dir(target)); err == nil && info.IsDir() {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: %w", target, ErrTargetNotFound)
+}
+
+// checkTarget makes sure target is a folder if it exists, and that nothing
+// but its absence keeps it from being looked at, such as a file in place of
+// one of its parents.
+func checkTarget(dst TargetFS, target string) error {
+	info, err := dst.Stat(target)
+	switch {
+	case err == nil && !info.IsDir():
+		return fmt.Errorf("%s: %w", target, ErrTargetNotDir)
+	case err != nil && !errors.Is(err, fs.ErrNotExist):
+		return fmt.Errorf("Cannot access target: %w", err)
+	}
+	return nil
+}
+
+// resolvePath returns the absolute path of path with its links resolved.
+// The part of it that doesn't exist yet is kept as it is.
+func resolvePath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	missing := ""
+	for {
+		real, err := filepath.EvalSymlinks(abs)
+		if err == nil {
+			return filepath.Join(real, missing), nil
+		}
+		parent := filepath.Dir(abs)
+		if !os.IsNotExist(err) || parent == abs {
+			return "", err
+		}
+		missing = filepath.Join(filepath.Base(abs), missing)
+		abs = parent
+	}
+}
+
+// checkOverlap makes sure none of the source folders is the target or
+// located inside it, comparing their resolved paths.
+func checkOverlap(sources []string, infos []os.FileInfo, target string) error {
+	realTarget, err := resolvePath(target)
+	if err != nil {
+		return fmt.Errorf("Cannot resolve target: %w", err)
+	}
+	for i, source := range sources {
+		if !infos[i].IsDir() {
+			continue
+		}
+		realSource, err := resolvePath(source)
+		if err != nil {
+			return fmt.Errorf("Cannot resolve source: %w", err)
+		}
+		if isWithin(realTarget, realSource) {
+			return fmt.Errorf("%s: %w", source, ErrSourceInTarget)
+		}
+	}
+	return nil
+}
+
+// targetBelow returns the path the target is found at in a walk of the
+// source folder, or "" if it isn't inside it.
+func (r *report) targetBelow(source string) string {
+	if r.target == "" {
+		return ""
+	}
+	realSource, err := resolvePath(source)
+	if err != nil || realSource == r.target || !isWithin(realSource, r.target) {
+		return ""
+	}
+	rel, _ := filepath.Rel(realSource, r.target)
+	return filepath.Join(source, rel)
+}
+
+// scanSources scans every source with getFilesAndDir and returns the
+// combined file count, size, folders and files. A file source is listed
+// relative to its own folder. With List, only the listed files are stat'ed.
+func scanSources(ctx context.Context, opts *Options, sources []string, infos []os.FileInfo, rep *report) (uint64, uint64, []fileEntry, []fileEntry) {
+	if opts.List != nil {
+		return listSource(ctx, rep.src, sources[0], infos[0], opts.List, opts.Follow, rep)
+	}
+	var totalFileCount, totalSize uint64
+	var folders, files []fileEntry
+	for i, source := range sources {
+		if info := infos[i]; !info.IsDir() {
+			// a file among several sources is copied into the target
+			totalFileCount++
+			totalSize += uint64(info.Size())
+			files = append(files, fileEntry{source, info, filepath.Dir(source)})
+			continue
+		}
+		fileCount, size, sourceFolders, sourceFiles := getFilesAndDir(ctx, rep.src, source, opts.Follow, opts.Threads, rep)
+		totalFileCount += fileCount
+		totalSize += size
+		folders = append(folders, sourceFolders...)
+		files = append(files, sourceFiles...)
+	}
+	return totalFileCount, totalSize, folders, files
+}
+
+// getFilesAndDir walks path in src and returns the file count, their total
+// size, and the folders and files found. Symbolic links are listed as files
+// unless follow is set, in which case they are resolved and linked folders
+// walked.
+//
+// Subfolders are read by up to threads goroutines at once, which pays off
+// where every read waits on the network. The entries are still returned in
+// the lexical order of a filepath.WalkDir, whatever the timing.
+func getFilesAndDir(ctx context.Context, src sourceFS, path string, follow bool, threads uint, rep *report) (uint64, uint64, []fileEntry, []fileEntry) {
+	root := &scanNode{}
+	walkFolder(ctx, src, path, follow, threads, rep, root, nil)
+
+	var filesCount, totalSize uint64
+	var directories, files []fileEntry
+	root.flatten(&directories, &files)
+	for _, file := range files {
+		filesCount++
+		if file.info.Mode().IsRegular() {
+			totalSize += uint64(file.info.Size())
+		}
+	}
+	return filesCount, totalSize, directories, files
+}
+
+// walkFolder walks the source folder path like getFilesAndDir, listing the
+// entries into root, or passing each to emit instead if it is not nil.
+// emit is called from several goroutines at once, for a folder before
+// anything below it.
+func walkFolder(ctx context.Context, src sourceFS, path string, follow bool, threads uint, rep *report, root *scanNode, emit func(fileEntry)) {
+	scanCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	s := &scanner{
+		ctx:     scanCtx,
+		cancel:  cancel,
+		src:     src,
+		source:  path,
+		root:    rep.rootOf(path),
+		target:  rep.targetBelow(path),
+		follow:  follow,
+		rep:     rep,
+		emit:    emit,
+		slots:   make(chan struct{}, max(1, threads)-1),
+		ignores: ignoreRules{},
+	}
+
+	info, err := src.Lstat(path)
+	if err != nil {
+		s.stop(err)
+	} else {
+		s.visit(root, path, path, fs.FileInfoToDirEntry(info), nil)
+	}
+	s.wg.Wait()
+
+	if s.err != nil && ctx.Err() == nil {
+		rep.fail("counting files in", path, s.err)
+	}
+}
+
+// scanner is the state of a getFilesAndDir walk shared by its goroutines.
+type scanner struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	src    sourceFS
+	source string
+	root   string // what the entries are named relative to in the target
+	target string // the target as found in the walk, if inside the source
+	follow bool
+	rep    *report
+	emit   func(fileEntry) // takes the entries instead of the nodes, if set
+	slots  chan struct{}   // a token per goroutine running besides the first
+	wg     sync.WaitGroup
+
+	ignoreMu sync.RWMutex
+	ignores  ignoreRules
+
+	errOnce sync.Once
+	err     error // the error that ended the walk
+}
+
+// scanNode holds the entries of a folder in walk order, unless they are
+// emitted. Each node is filled by a single goroutine, and a subfolder gets a
+// node of its own so another one can fill it meanwhile.
+type scanNode struct {
+	items []scanItem
+}
+
+// scanItem is either an entry or the node of a subfolder's contents.
+type scanItem struct {
+	entry fileEntry
+	sub   *scanNode
+}
+
+// flatten moves the folders and files of n, and of the nodes below it, to
+// the ends of directories and files in walk order. The nodes are emptied on
+// the way so the entries aren't held twice.
+func (n *scanNode) flatten(directories, files *[]fileEntry) {
+	for _, item := range n.items {
+		switch {
+		case item.sub != nil:
+			item.sub.flatten(directories, files)
+		case item.entry.info.IsDir():
+			*directories = append(*directories, item.entry)
+		default:
+			*files = append(*files, item.entry)
+		}
+	}
+	n.items = nil
+}
+
+// stop ends the walk with err, unless it already ended.
+func (s *scanner) stop(err error) {
+	s.errOnce.Do(func() {
+		s.err = err
+		s.cancel()
+	})
+}
+
+// skip records the entry at path the walk couldn't read: as unreadable for
+// lack of permission, as an error of the run otherwise.
+func (s *scanner) skip(op, path string, err error) {
+	if errors.Is(err, fs.ErrPermission) {
+		s.rep.skipUnreadable(path, err)
+		return
+	}
+	s.rep.fail(op, path, err)
+}
+
+// visit lists the entry d found at realPath into n, as if it were located at
+// pathInfo; they differ only below a followed folder link. links holds the
+// real parent folders of the links followed so far, to detect loops.
+func (s *scanner) visit(n *scanNode, realPath, pathInfo string, d fs.DirEntry, links []string) {
+	if err := s.ctx.Err(); err != nil {
+		return
+	}
+	rep := s.rep
+
+	relativePath, _ := filepath.Rel(s.source, pathInfo)
+	if relativePath != "." && (rep.exclude.match(relativePath) || s.ignored(relativePath, d.IsDir())) {
+		rep.excluded.Add(1)
+		return
+	}
+	if realPath == s.target || (len(links) > 0 && realPath == rep.target) {
+		// copying the target into itself would never end
+		rep.log.Warn("Leaving out the target, which is inside the source", "path", pathInfo)
+		return
+	}
+
+	info, err := d.Info()
+	if err != nil {
+		s.skip("reading", pathInfo, err)
+		return
+	}
+
+	if s.follow && d.Type()&os.ModeSymlink != 0 {
+		info, err = s.src.Stat(realPath)
+		if err != nil {
+			rep.fail("following link", pathInfo, err)
+			return
+		}
+		if info.IsDir() {
+			target, err := filepath.EvalSymlinks(realPath)
+			if err != nil {
+				rep.fail("following link", pathInfo, err)
+				return
+			}
+			parent, err := filepath.EvalSymlinks(filepath.Dir(realPath))
+			if err != nil {
+				rep.fail("following link", pathInfo, err)
+				return
+			}
+			chain := append(links[:len(links):len(links)], parent)
+			for _, link := range chain {
+				if isWithin(target, link) {
+					rep.fail("following link", pathInfo, errLinkLoop)
+					return
+				}
+			}
+			realPath, links = target, chain
+		}
+	}
+
+	if isSpecial(info.Mode()) && (!rep.special || info.Mode()&os.ModeSocket != 0) {
+		// reading a pipe or a device could block or never end
+		rep.log.Info("Skipping special file", "path", pathInfo)
+		rep.specials.Add(1)
+		return
+	}
+
+	switch {
+	case info.IsDir():
+		s.add(n, fileEntry{pathInfo, info, s.root})
+		if err := s.loadIgnores(realPath, relativePath); err != nil {
+			rep.fail("reading ignore files in", pathInfo, err)
+		}
+		s.descend(n, realPath, pathInfo, links)
+	case !rep.selects(relativePath, info):
+		rep.excluded.Add(1)
+	default:
+		s.add(n, fileEntry{pathInfo, info, s.root})
+	}
+}
+
+// add lists entry into n, or emits it.
+func (s *scanner) add(n *scanNode, entry fileEntry) {
+	if s.emit != nil {
+		s.emit(entry)
+		return
+	}
+	n.items = append(n.items, scanItem{entry: entry})
+}
+
+// descend walks the folder at realPath into a new node of n, in a goroutine
+// of its own if one is free and in this one otherwise.
+func (s *scanner) descend(n *scanNode, realPath, pathInfo string, links []string) {
+	var sub *scanNode
+	if s.emit == nil {
+		sub = &scanNode{}
+		n.items = append(n.items, scanItem{sub: sub})
+	}
+	select {
+	case s.slots <- struct{}{}:
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer func() { <-s.slots }()
+			s.walk(sub, realPath, pathInfo, links)
+		}()
+	default:
+		s.walk(sub, realPath, pathInfo, links)
+	}
+}
+
+// walk lists the entries of the folder at realPath, located at pathInfo,
+// into n. A folder that can't be read is skipped with whatever was read of
+// it, so the rest of the walk goes on.
+func (s *scanner) walk(n *scanNode, realPath, pathInfo string, links []string) {
+	entries, err := s.src.ReadDir(realPath)
+	if err != nil {
+		s.skip("reading directory", pathInfo, err)
+	}
+	for _, d := range entries {
+		s.visit(n, filepath.Join(realPath, d.Name()), filepath.Join(pathInfo, d.Name()), d, links)
+	}
+}
+
+// ignored reports whether relativePath is ignored by the ignore files read
+// so far.
+func (s *scanner) ignored(relativePath string, isDir bool) bool {
+	s.ignoreMu.RLock()
+	defer s.ignoreMu.RUnlock()
+	return s.ignores.ignored(relativePath, isDir)
+}
+
+// loadIgnores reads the ignore files of the folder at realPath, located at
+// relativePath in the source.
+func (s *scanner) loadIgnores(realPath, relativePath string) error {
+	if len(s.rep.ignoreFiles) == 0 {
+		return nil
+	}
+	s.ignoreMu.Lock()
+	defer s.ignoreMu.Unlock()
+	return s.ignores.load(s.src, realPath, relativePath, s.rep.ignoreFiles)
+}
+
+// isWithin reports whether path is parent itself or located below it.
+func isWithin(parent, path string) bool {
+	rel, err := filepath.Rel(parent, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,57 @@
+package gocp
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckOverlap(t *testing.T) {
+	dir := t.TempDir()
+	data := filepath.Join(dir, "data")
+	writeTree(t, dir, map[string]string{"data/data/x.txt": "x", "database/": ""})
+	sep := string(filepath.Separator)
+
+	tests := []struct {
+		source, target string
+		overlap        bool
+	}{
+		{data, data, true},
+		{data, data + sep, true},
+		{data + sep, data, true},
+		{filepath.Join(data, "data"), data, true},
+		{filepath.Join(data, "data") + sep, data + sep, true},
+		{data, filepath.Join(data, "data"), false},
+		{data, filepath.Join(dir, "database"), false},
+		{filepath.Join(dir, "database"), data, false},
+		{data, filepath.Join(dir, "missing", "target"), false},
+	}
+	for _, test := range tests {
+		info, err := os.Stat(test.source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = checkOverlap([]string{test.source}, []os.FileInfo{info}, test.target)
+		if got := errors.Is(err, ErrSourceInTarget); got != test.overlap {
+			t.Errorf("checkOverlap(%q, %q) = %v, want overlap %v", test.source, test.target, err, test.overlap)
+		}
+	}
+}
+
+// A path below the source may repeat its name, which the target path must
+// keep.
+func TestSourceNameRepeated(t *testing.T) {
+	for _, suffix := range []string{"", string(filepath.Separator)} {
+		t.Run("data"+suffix, func(t *testing.T) {
+			dir := t.TempDir()
+			data := filepath.Join(dir, "data")
+			target := filepath.Join(dir, "target")
+			tree := map[string]string{"data/x.txt": "x", "data/data/y.txt": "y", "z.txt": "z"}
+			writeTree(t, data, tree)
+
+			copyTree(t, Options{Source: data + suffix, Target: target + suffix})
+			checkTree(t, target, tree)
+		})
+	}
+}
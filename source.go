@@ -0,0 +1,108 @@
+package gocp
+
+import (
+	"crypto/sha256"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// ErrSourceFS is returned when Options asks for something that needs the
+// sources on the OS file system while SourceFS is set.
+var ErrSourceFS = errors.New("Follow, Move, Owner, Xattrs, ACL and Watch cannot be used with SourceFS.")
+
+// errFSLink is returned for symbolic links of a SourceFS, which can't be
+// read back through io/fs.
+var errFSLink = errors.New("symbolic links can't be read out of SourceFS")
+
+// sourceFS is what the sources are read through: the OS file system, or
+// the SourceFS of Options.
+type sourceFS interface {
+	Open(name string) (fs.File, error)
+	Stat(name string) (fs.FileInfo, error)
+	Lstat(name string) (fs.FileInfo, error)
+	ReadDir(name string) ([]fs.DirEntry, error)
+	ReadLink(name string) (string, error)
+}
+
+// osSource reads OS paths.
+type osSource struct{}
+
+func (osSource) Open(name string) (fs.File, error)          { return os.Open(name) }
+func (osSource) Stat(name string) (fs.FileInfo, error)      { return os.Stat(name) }
+func (osSource) Lstat(name string) (fs.FileInfo, error)     { return os.Lstat(name) }
+func (osSource) ReadDir(name string) ([]fs.DirEntry, error) { return os.ReadDir(name) }
+func (osSource) ReadLink(name string) (string, error)       { return os.Readlink(name) }
+
+// ioSource reads the paths of an fs.FS. The paths handed to it are built
+// with path/filepath like OS paths, so they are turned back into slash
+// separated paths first.
+type ioSource struct {
+	fsys fs.FS
+}
+
+func (s ioSource) Open(name string) (fs.File, error) {
+	return s.fsys.Open(filepath.ToSlash(name))
+}
+
+func (s ioSource) Stat(name string) (fs.FileInfo, error) {
+	return fs.Stat(s.fsys, filepath.ToSlash(name))
+}
+
+// Lstat is Stat, since io/fs doesn't tell a link from what it points to,
+// except in the entries of a folder.
+func (s ioSource) Lstat(name string) (fs.FileInfo, error) {
+	return s.Stat(name)
+}
+
+func (s ioSource) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(s.fsys, filepath.ToSlash(name))
+}
+
+func (s ioSource) ReadLink(name string) (string, error) {
+	return "", &fs.PathError{Op: "readlink", Path: name, Err: errFSLink}
+}
+
+// sourceFS returns what the sources of opts are read through.
+func (opts *Options) sourceFS() sourceFS {
+	if opts.SourceFS != nil {
+		return ioSource{opts.SourceFS}
+	}
+	return osSource{}
+}
+
+// checkSourceFS makes sure opts asks for nothing SourceFS can't do.
+func (opts *Options) checkSourceFS() error {
+	if opts.SourceFS != nil && (opts.Follow || opts.Move || opts.Owner || opts.Xattrs || opts.ACL) {
+		return ErrSourceFS
+	}
+	return nil
+}
+
+// readFile returns the content of name in src.
+func readFile(src sourceFS, name string) ([]byte, error) {
+	file, err := src.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// hashSource returns the SHA-256 of the content of name in src, which may
+// be any of what the sources are read or the target is written through.
+func hashSource(src fs.FS, name string) ([]byte, error) {
+	file, err := src.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	fileHash := sha256.New()
+	if _, err := io.Copy(fileHash, file); err != nil {
+		return nil, err
+	}
+	return fileHash.Sum(nil), nil
+}
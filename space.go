@@ -0,0 +1,42 @@
+package gocp
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/dustin/go-humanize"
+)
+
+// ErrInsufficientSpace is returned by Copy when the files to copy don't fit
+// in the free space of the target, unless Options.Force is set.
+var ErrInsufficientSpace = errors.New("Not enough free space in the target")
+
+// checkSpace makes sure the files to copy fit in the free space of the
+// target. In update mode the files already up to date are not counted.
+func checkSpace(opts *Options, files []fileEntry, totalSize uint64) error {
+	available, err := freeSpace(opts.Target)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to get the free space of the target: %w", err)
+	}
+
+	needed := totalSize
+	if opts.Update && needed > available {
+		for _, file := range files {
+			relativePath, _ := filepath.Rel(file.root, file.path)
+			destFile := opts.targetName(file.path, opts.targetPath(relativePath))
+			if file.info.Mode().IsRegular() && isUpToDate(osTarget{}, destFile, file.info, !opts.coded(file.path)) {
+				needed -= uint64(file.info.Size())
+			}
+		}
+	}
+
+	if needed > available {
+		return fmt.Errorf("%w: %s needed, %s available",
+			ErrInsufficientSpace, humanize.IBytes(needed), humanize.IBytes(available))
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+package gocp
+
+import "golang.org/x/sys/windows"
+
+// freeSpace returns the bytes available to the user on the volume holding
+// path.
+func freeSpace(path string) (uint64, error) {
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	var available, total, free uint64
+	if err := windows.GetDiskFreeSpaceEx(name, &available, &total, &free); err != nil {
+		return 0, err
+	}
+	return available, nil
+}
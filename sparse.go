@@ -0,0 +1,137 @@
+package gocp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+)
+
+// sparseBlockSize is the size of the runs of zeros Sparse leaves as holes,
+// that of a block on most file systems.
+const sparseBlockSize = 4 << 10
+
+// zeroBlock is a block of zeros to compare against.
+var zeroBlock = make([]byte, sparseBlockSize)
+
+// dataRegion is a range of a file that may hold data, from start to end.
+type dataRegion struct {
+	start, end int64
+}
+
+// writeFileSparse copies src to the new file tmp like writeFile, but leaves
+// holes in tmp where src has them, or holds whole blocks of zeros, instead
+// of writing zeros. The holes of src aren't read at all where the platform
+// can find them. The SHA-256 Checksums asks for is read back afterwards.
+func writeFileSparse(ctx context.Context, src, tmp string, info os.FileInfo, perm os.FileMode, opts *Options, rep *report) ([]byte, error) {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot open source file: %w", err)
+	}
+	defer srcFile.Close()
+
+	dstFile, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create target file: %w", err)
+	}
+	defer dstFile.Close()
+
+	size := info.Size()
+	regions, err := dataRegions(srcFile, size)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read source file: %w", err)
+	}
+
+	sparse := &sparseWriter{file: dstFile}
+	var writer io.Writer = sparse
+	if rep.limiter != nil {
+		writer = limitedWriter{ctx, sparse, rep.limiter}
+	}
+	buf := getBuffer(opts.BufferSize)
+	defer bufferPool.Put(buf)
+
+	// offset is where the data copied so far ends
+	var offset int64
+	for _, region := range regions {
+		// the hole up to the region counts as copied
+		opts.Progress.Add(region.start - offset)
+		sparse.skip += region.start - offset
+		if _, err := srcFile.Seek(region.start, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("Failed to copy file: %w", err)
+		}
+		reader := progressReader{contextReader{ctx, io.LimitReader(srcFile, region.end-region.start)}, opts.Progress}
+		n, err := io.CopyBuffer(struct{ io.Writer }{writer}, reader, *buf)
+		offset = region.start + n
+		if err != nil {
+			return nil, fmt.Errorf("Failed to copy file: %w", err)
+		}
+	}
+	if offset < size {
+		opts.Progress.Add(size - offset)
+	}
+
+	// the zeros skipped at the end are only there once the size is set
+	if err := dstFile.Truncate(size); err != nil {
+		return nil, fmt.Errorf("Failed to size target file: %w", err)
+	}
+
+	if opts.Fsync {
+		if err := dstFile.Sync(); err != nil {
+			return nil, fmt.Errorf("Failed to sync target file: %w", err)
+		}
+	}
+
+	if err := dstFile.Close(); err != nil {
+		return nil, fmt.Errorf("Failed to close target file: %w", err)
+	}
+
+	// the holes weren't read, so hash the source separately
+	return hashAfter(src, tmp, opts)
+}
+
+// sparseWriter writes to a file, seeking over the blocks of zeros instead of
+// writing them so they are left as holes.
+type sparseWriter struct {
+	file *os.File
+	skip int64 // the zeros to seek over before the next write
+}
+
+func (w *sparseWriter) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		// gather the blocks up to the next one of zeros
+		end := written
+		for end < len(p) {
+			next := min(end+sparseBlockSize, len(p))
+			if isZero(p[end:next]) {
+				break
+			}
+			end = next
+		}
+		if end == written {
+			next := min(written+sparseBlockSize, len(p))
+			w.skip += int64(next - written)
+			written = next
+			continue
+		}
+
+		if w.skip > 0 {
+			if _, err := w.file.Seek(w.skip, io.SeekCurrent); err != nil {
+				return written, err
+			}
+			w.skip = 0
+		}
+		n, err := w.file.Write(p[written:end])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// isZero reports whether block holds nothing but zeros.
+func isZero(block []byte) bool {
+	return bytes.Equal(block, zeroBlock[:len(block)])
+}
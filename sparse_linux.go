@@ -0,0 +1,41 @@
+package gocp
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// dataRegions returns the regions of file, of size bytes, that hold data,
+// found with SEEK_DATA and SEEK_HOLE. A file system that can't tell them
+// apart has the whole file as a single region.
+func dataRegions(file *os.File, size int64) ([]dataRegion, error) {
+	var regions []dataRegion
+	for offset := int64(0); offset < size; {
+		start, err := file.Seek(offset, unix.SEEK_DATA)
+		if errors.Is(err, syscall.ENXIO) {
+			// nothing but a hole is left
+			break
+		}
+		if errors.Is(err, syscall.EINVAL) || errors.Is(err, syscall.EOPNOTSUPP) {
+			return []dataRegion{{0, size}}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		end, err := file.Seek(start, unix.SEEK_HOLE)
+		if err != nil {
+			return nil, err
+		}
+		if start >= size {
+			break
+		}
+		regions = append(regions, dataRegion{start, min(end, size)})
+		offset = end
+	}
+	_, err := file.Seek(0, io.SeekStart)
+	return regions, err
+}
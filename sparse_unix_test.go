@@ -0,0 +1,66 @@
+//go:build unix
+
+package gocp
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// allocated returns the bytes allocated to path on disk.
+func allocated(t *testing.T, path string) (int64, int64) {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size(), info.Sys().(*syscall.Stat_t).Blocks * 512
+}
+
+func TestSparse(t *testing.T) {
+	const size = 16 << 20
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source")
+	target := filepath.Join(dir, "target")
+	if err := os.Mkdir(source, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	// a file with a hole between data at either end, and one of zeros
+	// written out
+	data := bytes.Repeat([]byte("data"), 1024)
+	holes, err := os.Create(filepath.Join(source, "holes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	holes.Write(data)
+	holes.WriteAt(data, size-int64(len(data)))
+	if err := holes.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, blocks := allocated(t, filepath.Join(source, "holes")); blocks >= size/2 {
+		t.Skip("the file system doesn't support holes")
+	}
+	if err := os.WriteFile(filepath.Join(source, "zeros"), make([]byte, size), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	copyTree(t, Options{Source: source, Target: target, Sparse: true, Reflink: ReflinkNever})
+	for _, name := range []string{"holes", "zeros"} {
+		gotSize, blocks := allocated(t, filepath.Join(target, name))
+		if gotSize != size {
+			t.Errorf("%s: got size %d, want %d", name, gotSize, size)
+		}
+		if blocks >= size/2 {
+			t.Errorf("%s: %d bytes allocated, want holes in the %d", name, blocks, size)
+		}
+		want, _ := os.ReadFile(filepath.Join(source, name))
+		got, _ := os.ReadFile(filepath.Join(target, name))
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: the copy differs from the source", name)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package gocp
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// errSpecialUnsupported is returned by copySpecial for special files that
+// can't be recreated on this platform.
+var errSpecialUnsupported = errors.New("special file type not supported")
+
+// isSpecial reports whether mode is that of a named pipe, socket or device.
+func isSpecial(mode os.FileMode) bool {
+	return mode&(os.ModeNamedPipe|os.ModeSocket|os.ModeDevice|os.ModeCharDevice|os.ModeIrregular) != 0
+}
+
+// copySpecial recreates the named pipe or device node src at dst, under a
+// temporary name renamed into place like copySymlink does.
+func copySpecial(src, dst string, info os.FileInfo, opts *Options, rep *report) error {
+	tmp := tempPath(dst)
+	if err := mknod(tmp, info); err != nil {
+		return fmt.Errorf("Failed to create target special file: %w", err)
+	}
+	if opts.Owner {
+		if err := rep.preserveOwner(opts.Logger, tmp, info, false); err != nil {
+			os.Remove(tmp)
+			return fmt.Errorf("Failed to set target special file owner: %w", err)
+		}
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("Failed to move target special file into place: %w", err)
+	}
+
+	return nil
+}
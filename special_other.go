@@ -0,0 +1,10 @@
+//go:build !(linux || darwin)
+
+package gocp
+
+import "os"
+
+// mknod is not implemented on this platform.
+func mknod(path string, info os.FileInfo) error {
+	return errSpecialUnsupported
+}
@@ -0,0 +1,32 @@
+//go:build linux || darwin
+
+package gocp
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// mknod creates a named pipe or device node at path like the one described
+// by info.
+func mknod(path string, info os.FileInfo) error {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return errSpecialUnsupported
+	}
+
+	mode := uint32(info.Mode().Perm())
+	switch {
+	case info.Mode()&os.ModeNamedPipe != 0:
+		mode |= unix.S_IFIFO
+	case info.Mode()&os.ModeCharDevice != 0:
+		mode |= unix.S_IFCHR
+	case info.Mode()&os.ModeDevice != 0:
+		mode |= unix.S_IFBLK
+	default:
+		return errSpecialUnsupported
+	}
+	return unix.Mknod(path, mode, int(stat.Rdev))
+}
@@ -0,0 +1,126 @@
+package gocp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime/debug"
+	"sync"
+)
+
+// writeFileSplit copies src to the new file tmp like writeFile, but divides
+// it into one byte range per thread, copied in parallel with ReadAt and
+// WriteAt. The ranges of all the files split at once share Threads slots,
+// so that a run doesn't copy Threads of them for each worker. The SHA-256
+// Checksums asks for is read back afterwards.
+func writeFileSplit(ctx context.Context, src, tmp string, info os.FileInfo, perm os.FileMode, opts *Options, rep *report) ([]byte, error) {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot open source file: %w", err)
+	}
+	defer srcFile.Close()
+
+	dstFile, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create target file: %w", err)
+	}
+	defer dstFile.Close()
+
+	// size the file up front, so the ranges can be written in any order
+	size := info.Size()
+	if opts.Preallocate {
+		preallocate(dstFile, size)
+	}
+	if err := dstFile.Truncate(size); err != nil {
+		return nil, fmt.Errorf("Failed to size target file: %w", err)
+	}
+
+	parts := int64(opts.Threads)
+	partSize := (size + parts - 1) / parts
+	errs := make([]error, parts)
+	var wg sync.WaitGroup
+	for i := int64(0); i < parts && i*partSize < size; i++ {
+		select {
+		case rep.ranges <- struct{}{}:
+		case <-ctx.Done():
+			errs[i] = ctx.Err()
+		}
+		if errs[i] != nil {
+			break
+		}
+		offset := i * partSize
+		length := min(partSize, size-offset)
+		wg.Add(1)
+		go func(i int64) {
+			defer wg.Done()
+			defer func() { <-rep.ranges }()
+			defer func() {
+				if v := recover(); v != nil {
+					errs[i] = &PanicError{Value: v, Stack: debug.Stack()}
+				}
+			}()
+			errs[i] = copyRangeAt(ctx, srcFile, dstFile, offset, length, opts, rep)
+		}(i)
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("Failed to copy file: %w", err)
+	}
+
+	if opts.Fsync {
+		if err := dstFile.Sync(); err != nil {
+			return nil, fmt.Errorf("Failed to sync target file: %w", err)
+		}
+	}
+
+	if err := dstFile.Close(); err != nil {
+		return nil, fmt.Errorf("Failed to close target file: %w", err)
+	}
+
+	// the ranges weren't read in order, so hash the source separately
+	return hashAfter(src, tmp, opts)
+}
+
+// hashAfter verifies tmp, the copy of src, against a SHA-256 of src read
+// anew, or hashes tmp for Checksums, and returns the sum.
+func hashAfter(src, tmp string, opts *Options) ([]byte, error) {
+	if opts.Verify {
+		sum, err := hashFile(src)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read source file: %w", err)
+		}
+		if err := verifyFile(osSource{}, tmp, sum); err != nil {
+			return nil, fmt.Errorf("Failed to verify target file: %w", err)
+		}
+		return sum, nil
+	}
+	if opts.Checksums {
+		sum, err := hashFile(tmp)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read target file: %w", err)
+		}
+		return sum, nil
+	}
+
+	return nil, nil
+}
+
+// copyRangeAt copies length bytes at offset from src to the same place in
+// dst through a pooled buffer.
+func copyRangeAt(ctx context.Context, src, dst *os.File, offset, length int64, opts *Options, rep *report) error {
+	reader := progressReader{contextReader{ctx, io.NewSectionReader(src, offset, length)}, opts.Progress}
+	var writer io.Writer = struct{ io.Writer }{io.NewOffsetWriter(dst, offset)}
+	if rep.limiter != nil {
+		writer = limitedWriter{ctx, writer, rep.limiter}
+	}
+	if opts.ReadAhead > 0 {
+		_, err := copyReadAhead(writer, reader, opts.BufferSize, opts.ReadAhead)
+		return err
+	}
+	buf := getBuffer(opts.BufferSize)
+	defer bufferPool.Put(buf)
+	_, err := io.CopyBuffer(writer, reader, *buf)
+	return err
+}
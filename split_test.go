@@ -0,0 +1,86 @@
+package gocp
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// rangeProgress tracks how many ranges add their progress at once, each
+// taking a while to.
+type rangeProgress struct {
+	mu           sync.Mutex
+	active, peak int
+}
+
+func (p *rangeProgress) Start(files, bytes uint64) {}
+func (p *rangeProgress) Increment()                {}
+func (p *rangeProgress) Finish()                   {}
+
+func (p *rangeProgress) Add(n int64) {
+	p.mu.Lock()
+	p.active++
+	p.peak = max(p.peak, p.active)
+	p.mu.Unlock()
+	time.Sleep(10 * time.Millisecond)
+	p.mu.Lock()
+	p.active--
+	p.mu.Unlock()
+}
+
+func TestSplitRangesShareThreads(t *testing.T) {
+	const threads = 4
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source")
+	target := filepath.Join(dir, "target")
+	tree := map[string]string{}
+	for i := 0; i < 2*threads; i++ {
+		tree[fmt.Sprintf("f%d", i)] = strings.Repeat(fmt.Sprint(i), 256<<10)
+	}
+	writeTree(t, source, tree)
+
+	progress := &rangeProgress{}
+	copyTree(t, Options{
+		Source:         source,
+		Target:         target,
+		Threads:        threads,
+		SplitThreshold: 1,
+		BufferSize:     16 << 10,
+		Reflink:        ReflinkNever,
+		Progress:       progress,
+	})
+	if progress.peak > threads {
+		t.Errorf("%d ranges were copied at once, want %d at most", progress.peak, threads)
+	}
+	checkTree(t, target, tree)
+}
+
+// The ranges of a split file report their progress to the attempt of
+// Retries at once; run with -race.
+func TestSplitWithRetries(t *testing.T) {
+	const size = 64 << 20
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source")
+	target := filepath.Join(dir, "target")
+	tree := map[string]string{"file": strings.Repeat("split", size/5)}
+	writeTree(t, source, tree)
+
+	progress := &countingProgress{}
+	copyTree(t, Options{
+		Source:         source,
+		Target:         target,
+		Threads:        4,
+		SplitThreshold: 1,
+		BufferSize:     4 << 10,
+		Reflink:        ReflinkNever,
+		Retries:        2,
+		Progress:       progress,
+	})
+	if want := int64(len(tree["file"])); progress.added != want {
+		t.Errorf("progress added %d bytes, want %d", progress.added, want)
+	}
+	checkTree(t, target, tree)
+}
@@ -0,0 +1,88 @@
+package gocp
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// streams reports whether the files of the run are copied as they are
+// scanned, following Stream.
+func (opts *Options) streams() bool {
+	return opts.Stream && len(opts.sources()) == 1 && !opts.Flatten && opts.Normalize == NormalizeNone && !opts.Dedup &&
+		!opts.NoEmptyDirs && opts.List == nil && opts.Order == OrderScan
+}
+
+// streamSources copies the sources into the target while they are scanned,
+// until queue is done, and the files taken by then with ctx.
+// Each folder is created when found, before anything below it, and each
+// file is queued for the copy workers right away, so only the folders are
+// kept. It returns the combined file count and size, and the folders with
+// every folder ahead of those below it.
+func streamSources(ctx, queue context.Context, opts *Options, sources []string, infos []os.FileInfo, rep *report) (uint64, uint64, []fileEntry) {
+	start := time.Now()
+	opts.Progress.Start(0, 0)
+	totals, _ := opts.Progress.(TotalProgress)
+
+	// the walkers wait for a free worker, so the scan never runs far ahead
+	// of the copy
+	pool := NewThreadPool(int(opts.Threads))
+
+	var mu sync.Mutex
+	var filesCount, totalSize uint64
+	var folders []fileEntry
+	emit := func(entry fileEntry) {
+		if entry.info.IsDir() {
+			createFolders(queue, opts, []fileEntry{entry}, rep)
+			mu.Lock()
+			folders = append(folders, entry)
+			mu.Unlock()
+			return
+		}
+
+		mu.Lock()
+		filesCount++
+		if entry.info.Mode().IsRegular() {
+			totalSize += uint64(entry.info.Size())
+		}
+		if totals != nil {
+			totals.SetTotal(filesCount, totalSize)
+		}
+		mu.Unlock()
+
+		relativePath, _ := filepath.Rel(entry.root, entry.path)
+		destFile := opts.targetPath(relativePath)
+		pool.Submit(queue, func() {
+			if opts.drained() {
+				return
+			}
+			copyEntry(ctx, opts, entry, destFile, rep)
+		}, func(p *PanicError) {
+			rep.fail("copying file", entry.path, p)
+			opts.Progress.Increment()
+		})
+	}
+
+	for i, source := range sources {
+		if info := infos[i]; !info.IsDir() {
+			// a file among several sources is copied into the target
+			emit(fileEntry{source, info, filepath.Dir(source)})
+			continue
+		}
+		walkFolder(queue, rep.src, source, opts.Follow, opts.Threads, rep, nil, emit)
+	}
+	rep.scanElapsed = time.Since(start)
+	opts.Logger.Info("Scanned the source", "size", humanize.IBytes(totalSize),
+		"files", filesCount, "folders", len(folders), "elapsed", rep.scanElapsed)
+	if opts.OnScanComplete != nil && queue.Err() == nil {
+		opts.OnScanComplete(filesCount, totalSize)
+	}
+
+	pool.Stop()
+	opts.Progress.Finish()
+	return filesCount, totalSize, folders
+}
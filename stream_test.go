@@ -0,0 +1,97 @@
+package gocp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/metrics"
+	"testing"
+	"time"
+)
+
+// largeTree writes a synthetic tree of folders × files empty files under
+// dir, with long enough names to weigh on the file list.
+func largeTree(b *testing.B, dir string, folders, files int) {
+	b.Helper()
+	for i := 0; i < folders; i++ {
+		folder := filepath.Join(dir, fmt.Sprintf("folder-%04d-with-a-longer-name", i))
+		if err := os.MkdirAll(folder, 0o755); err != nil {
+			b.Fatal(err)
+		}
+		for j := 0; j < files; j++ {
+			if err := os.WriteFile(filepath.Join(folder, fmt.Sprintf("file-%05d-with-a-longer-name", j)), nil, 0o644); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// sampleHeap samples the live heap until the returned function is called,
+// which returns the largest size seen.
+func sampleHeap() func() uint64 {
+	runtime.GC()
+	samples := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	done := make(chan struct{})
+	peak := make(chan uint64)
+	go func() {
+		var largest uint64
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+		for {
+			metrics.Read(samples)
+			largest = max(largest, samples[0].Value.Uint64())
+			select {
+			case <-done:
+				peak <- largest
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return func() uint64 {
+		close(done)
+		return <-peak
+	}
+}
+
+// BenchmarkLargeTree compares the heap a copy of a large tree peaks at when
+// the whole file list is scanned first, when it is copied with Stream, and
+// when it is written to a tar archive, which streams as well.
+func BenchmarkLargeTree(b *testing.B) {
+	source := filepath.Join(b.TempDir(), "source")
+	largeTree(b, source, 100, 500)
+
+	run := func(b *testing.B, copy func(target string) error) {
+		var peak uint64
+		for i := 0; i < b.N; i++ {
+			target := filepath.Join(b.TempDir(), "target")
+			stop := sampleHeap()
+			if err := copy(target); err != nil {
+				b.Fatal(err)
+			}
+			peak = max(peak, stop())
+		}
+		b.ReportMetric(float64(peak), "peak-heap-B")
+	}
+	b.Run("scan", func(b *testing.B) {
+		run(b, func(target string) error {
+			_, err := Copy(context.Background(), Options{Source: source, Target: target})
+			return err
+		})
+	})
+	b.Run("stream", func(b *testing.B) {
+		run(b, func(target string) error {
+			_, err := Copy(context.Background(), Options{Source: source, Target: target, Stream: true})
+			return err
+		})
+	})
+	b.Run("tar", func(b *testing.B) {
+		run(b, func(string) error {
+			_, err := Tar(context.Background(), Options{Source: source}, io.Discard)
+			return err
+		})
+	})
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package gocp
+
+// syncDir is a no-op where folders can't be synced.
+func syncDir(dir string) error {
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build unix
+
+package gocp
+
+import "os"
+
+// syncDir flushes the entries of the folder dir to disk, so a file renamed
+// into it survives a power loss.
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
@@ -0,0 +1,245 @@
+package gocp
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// tarItem is a file read ahead for the tar writer. data is nil for files
+// too large to hold in memory, which the writer streams itself.
+type tarItem struct {
+	data []byte
+	err  error
+}
+
+// tarQueued is an entry of the archive waiting for the writer, with the
+// channel its read ahead arrives on.
+type tarQueued struct {
+	entry fileEntry
+	item  chan tarItem
+}
+
+// Tar writes opts.Source and opts.Sources to w as a tar archive instead of
+// copying them into opts.Target, keeping their paths relative to the
+// sources, modes, owners and modification times. The same filters as Copy
+// apply. The archive is written while the sources are scanned, each folder
+// ahead of its contents, and only the entries read ahead are held. Files up
+// to opts.BufferSize are read ahead by opts.Threads workers while a single
+// writer emits them in order; larger ones are streamed when their turn
+// comes.
+//
+// Failures to read a file before its header is written skip the file and
+// are collected in the returned Result like Copy does. Failing to write to w,
+// or a file changing size while it is streamed, leaves the archive broken
+// and ends the run with an error.
+func Tar(ctx context.Context, opts Options, w io.Writer) (Result, error) {
+	if opts.Threads == 0 {
+		opts.Threads = DefaultThreads()
+	}
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = DefaultBufferSize
+	}
+	if opts.Progress == nil {
+		opts.Progress = noProgress{}
+	}
+	if opts.Logger == nil {
+		opts.Logger = discardLogger
+	}
+	if err := opts.checkRootName(); err != nil {
+		return Result{}, err
+	}
+
+	start := time.Now()
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	rep, err := newReport(&opts, cancel)
+	if err != nil {
+		return Result{}, err
+	}
+
+	sources := opts.sources()
+	if err := opts.checkSourceFS(); err != nil {
+		return Result{}, err
+	}
+	sourceInfos, err := statSources(rep.src, sources)
+	if err != nil {
+		return Result{}, err
+	}
+
+	opts.Progress.Start(0, 0)
+	totals, _ := opts.Progress.(TotalProgress)
+
+	// queue holds the entries being read ahead, in archive order; its
+	// capacity bounds how many of them are buffered at once.
+	queue := make(chan tarQueued, opts.Threads)
+	var totalFileCount, totalSize uint64
+	var folderCount int
+	go func() {
+		defer close(queue)
+		pool := NewThreadPool(int(opts.Threads))
+		defer pool.Stop()
+		// a single walker emits the entries in archive order
+		emit := func(entry fileEntry) {
+			queued := tarQueued{entry, make(chan tarItem, 1)}
+			select {
+			case queue <- queued:
+			case <-runCtx.Done():
+				return
+			}
+			if entry.info.IsDir() {
+				folderCount++
+				queued.item <- tarItem{}
+				return
+			}
+			totalFileCount++
+			if entry.info.Mode().IsRegular() {
+				totalSize += uint64(entry.info.Size())
+			}
+			if totals != nil {
+				totals.SetTotal(totalFileCount, totalSize)
+			}
+			err := pool.Submit(runCtx, func() {
+				queued.item <- readTarItem(rep.src, entry, opts.BufferSize)
+			}, func(p *PanicError) {
+				queued.item <- tarItem{err: p}
+			})
+			if err != nil {
+				queued.item <- tarItem{err: err}
+			}
+		}
+		for i, source := range sources {
+			if info := sourceInfos[i]; !info.IsDir() {
+				emit(fileEntry{source, info, filepath.Dir(source)})
+				continue
+			}
+			walkFolder(runCtx, rep.src, source, opts.Follow, 1, rep, nil, emit)
+		}
+	}()
+
+	tw := tar.NewWriter(w)
+	for queued := range queue {
+		// the entries still queued are dropped once writing stops
+		if err != nil || runCtx.Err() != nil {
+			continue
+		}
+		if queued.entry.info.IsDir() {
+			err = writeTarHeader(tw, queued.entry, "", opts.RootName)
+		} else {
+			err = writeTarEntry(runCtx, tw, &opts, queued.entry, <-queued.item, rep)
+			opts.Progress.Increment()
+		}
+		if err != nil {
+			cancel()
+		}
+	}
+	opts.Progress.Finish()
+	opts.Logger.Info("Scanned the source", "size", humanize.IBytes(totalSize),
+		"files", totalFileCount, "folders", folderCount, "elapsed", time.Since(start))
+
+	if err == nil {
+		// end the archive after the last entry written, even when stopped early
+		err = tw.Close()
+	}
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	return rep.result(totalFileCount, totalSize, folderCount, start), err
+}
+
+// readTarItem reads file out of src into memory if it is a regular file of
+// up to limit bytes.
+func readTarItem(src sourceFS, file fileEntry, limit int) tarItem {
+	if !file.info.Mode().IsRegular() || file.info.Size() > int64(limit) {
+		return tarItem{}
+	}
+	data, err := readFile(src, file.path)
+	if err == nil && int64(len(data)) != file.info.Size() {
+		err = fmt.Errorf("Size changed from %d to %d bytes while reading", file.info.Size(), len(data))
+	}
+	if data == nil {
+		data = []byte{}
+	}
+	return tarItem{data: data, err: err}
+}
+
+// writeTarEntry writes the header and content of file. It returns an error
+// only when the archive can't be continued.
+func writeTarEntry(ctx context.Context, tw *tar.Writer, opts *Options, file fileEntry, item tarItem, rep *report) error {
+	size := uint64(file.info.Size())
+	if item.err != nil {
+		rep.fail("reading file", file.path, item.err)
+		rep.failedBytes.Add(size)
+		return nil
+	}
+
+	var link string
+	if file.info.Mode()&os.ModeSymlink != 0 {
+		var err error
+		if link, err = rep.src.ReadLink(file.path); err != nil {
+			rep.fail("reading link", file.path, err)
+			return nil
+		}
+	}
+
+	if !file.info.Mode().IsRegular() {
+		if err := writeTarHeader(tw, file, link, opts.RootName); err != nil {
+			return err
+		}
+	} else if item.data != nil {
+		if err := writeTarHeader(tw, file, "", opts.RootName); err != nil {
+			return err
+		}
+		if _, err := tw.Write(item.data); err != nil {
+			return err
+		}
+		opts.Progress.Add(int64(len(item.data)))
+	} else {
+		f, err := rep.src.Open(file.path)
+		if err != nil {
+			rep.fail("reading file", file.path, err)
+			rep.failedBytes.Add(size)
+			return nil
+		}
+		defer f.Close()
+		if err := writeTarHeader(tw, file, "", opts.RootName); err != nil {
+			return err
+		}
+		// the header promised the size, so a shorter file breaks the archive
+		r := progressReader{contextReader{ctx, f}, opts.Progress}
+		if _, err := io.CopyN(tw, r, file.info.Size()); err != nil {
+			return fmt.Errorf("Failed to archive %s: %w", file.path, err)
+		}
+	}
+
+	rep.copied.Add(1)
+	rep.bytes.Add(size)
+	if opts.Verbose {
+		opts.Logger.Debug("Archived", "path", file.path)
+	}
+	return nil
+}
+
+// writeTarHeader writes the header of entry, named by its path relative to
+// its source root, in the folder rootName if it is not empty.
+func writeTarHeader(tw *tar.Writer, entry fileEntry, link, rootName string) error {
+	relativePath, _ := filepath.Rel(entry.root, entry.path)
+	if relativePath == "." && rootName == "" {
+		return nil
+	}
+	hdr, err := tar.FileInfoHeader(entry.info, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = filepath.ToSlash(filepath.Join(rootName, relativePath))
+	if entry.info.IsDir() {
+		hdr.Name += "/"
+	}
+	return tw.WriteHeader(hdr)
+}
@@ -0,0 +1,89 @@
+package gocp
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// ErrTargetFS is returned when Options asks for something that needs the
+// target on the OS file system while TargetFS is set.
+var ErrTargetFS = errors.New("Owner, Xattrs, ACL, Hardlinks, Dedup, Special, Mirror, Manifest, Compare and Watch cannot be used with TargetFS.")
+
+// TargetFS is a file system the target is written to instead of the OS one,
+// such as a folder of a remote server. It is handed the paths of Target
+// joined with path/filepath, and its methods are called from several
+// workers at once.
+type TargetFS interface {
+	// Create creates or truncates the file name with the permission bits
+	// perm and opens it for writing.
+	Create(name string, perm fs.FileMode) (io.WriteCloser, error)
+	// Open opens the file name for reading, to verify or hash it.
+	Open(name string) (fs.File, error)
+	Stat(name string) (fs.FileInfo, error)
+	Lstat(name string) (fs.FileInfo, error)
+	MkdirAll(name string, perm fs.FileMode) error
+	// Rename moves oldname to newname, replacing newname if it exists.
+	Rename(oldname, newname string) error
+	Remove(name string) error
+	Chmod(name string, mode fs.FileMode) error
+	Chtimes(name string, atime, mtime time.Time) error
+	// Symlink creates newname as a symbolic link to oldname.
+	Symlink(oldname, newname string) error
+}
+
+// DirectTargetFS may be implemented by a TargetFS whose files only show once
+// complete and can't be renamed cheaply, such as the objects of a bucket.
+// Files are then written under their own name with CreateDirect, instead
+// of under a temporary name renamed into place.
+type DirectTargetFS interface {
+	TargetFS
+	// CreateDirect creates name for writing. It only shows, replacing any
+	// file of that name, once the returned file is committed, and not at
+	// all if it is closed first.
+	CreateDirect(name string, perm fs.FileMode) (DirectFile, error)
+}
+
+// DirectFile is a file of a DirectTargetFS being written.
+type DirectFile interface {
+	io.WriteCloser
+	Commit() error
+}
+
+// osTarget writes OS paths.
+type osTarget struct{}
+
+func (osTarget) Create(name string, perm fs.FileMode) (io.WriteCloser, error) {
+	return os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+}
+
+func (osTarget) Open(name string) (fs.File, error)            { return os.Open(name) }
+func (osTarget) Stat(name string) (fs.FileInfo, error)        { return os.Stat(name) }
+func (osTarget) Lstat(name string) (fs.FileInfo, error)       { return os.Lstat(name) }
+func (osTarget) MkdirAll(name string, perm fs.FileMode) error { return os.MkdirAll(name, perm) }
+func (osTarget) Rename(oldname, newname string) error         { return os.Rename(oldname, newname) }
+func (osTarget) Remove(name string) error                     { return os.Remove(name) }
+func (osTarget) Chmod(name string, mode fs.FileMode) error    { return os.Chmod(name, mode) }
+func (osTarget) Symlink(oldname, newname string) error        { return os.Symlink(oldname, newname) }
+func (osTarget) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+// targetFS returns what the target of opts is written through.
+func (opts *Options) targetFS() TargetFS {
+	if opts.TargetFS != nil {
+		return opts.TargetFS
+	}
+	return osTarget{}
+}
+
+// checkTargetFS makes sure opts asks for nothing TargetFS can't do.
+func (opts *Options) checkTargetFS() error {
+	if opts.TargetFS != nil && (opts.Owner || opts.Xattrs || opts.ACL || opts.Hardlinks || opts.Dedup ||
+		opts.Special || opts.Mirror || opts.Manifest != "") {
+		return ErrTargetFS
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package gocp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// errFileTimeout is the cause of the context of a file that took longer
+// than Options.FileTimeout.
+var errFileTimeout = errors.New("file timeout")
+
+// abandonGrace is how long a timed out copy gets to notice and clean up
+// before it is abandoned.
+const abandonGrace = time.Second
+
+// copyWithTimeout runs copy with a context that expires after timeout, unless
+// it is 0, and returns the checksum and the error of the copy. A copy still
+// running abandonGrace later is abandoned rather than waited for, since it
+// may be stuck in a read that never returns; it stops at its next read and
+// removes its temporary file. Until then, if the read returns, it still
+// writes what it read, which an in-place or Delta copy writes into the
+// target itself, and reports it to Progress. Its outcome is dropped.
+func copyWithTimeout(ctx context.Context, timeout time.Duration, copy func(context.Context) ([]byte, error)) ([]byte, error) {
+	if timeout <= 0 {
+		return copy(ctx)
+	}
+	fileCtx, cancel := context.WithTimeoutCause(ctx, timeout, errFileTimeout)
+	defer cancel()
+
+	type outcome struct {
+		sum []byte
+		err error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		sum, err := copy(fileCtx)
+		done <- outcome{sum, err}
+	}()
+	select {
+	case out := <-done:
+		if out.err != nil && context.Cause(fileCtx) == errFileTimeout {
+			return nil, fmt.Errorf("Timed out after %v: %w", timeout, out.err)
+		}
+		return out.sum, out.err
+	case <-fileCtx.Done():
+		if ctx.Err() != nil {
+			// the whole run was canceled, so let the copy clean up
+			out := <-done
+			return out.sum, out.err
+		}
+		timer := time.NewTimer(abandonGrace)
+		defer timer.Stop()
+		select {
+		case <-done:
+		case <-timer.C:
+		}
+		return nil, fmt.Errorf("Timed out after %v", timeout)
+	}
+}
@@ -0,0 +1,65 @@
+package gocp
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stuckFS is a file system whose file "stuck" hangs on its first read until
+// release is closed.
+type stuckFS struct {
+	fs.FS
+	release chan struct{}
+}
+
+func (s stuckFS) Open(name string) (fs.File, error) {
+	file, err := s.FS.Open(name)
+	if err != nil || name != "stuck" {
+		return file, err
+	}
+	return stuckFile{file, s.release}, nil
+}
+
+type stuckFile struct {
+	fs.File
+	release chan struct{}
+}
+
+func (f stuckFile) Read(p []byte) (int, error) {
+	<-f.release
+	return f.File.Read(p)
+}
+
+func TestFileTimeoutAbandonsCopy(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source")
+	target := filepath.Join(dir, "target")
+	writeTree(t, source, map[string]string{"stuck": "stuck", "fine": "fine"})
+	release := make(chan struct{})
+
+	result, err := Copy(context.Background(), Options{
+		SourceFS:    stuckFS{os.DirFS(source), release},
+		Source:      ".",
+		Target:      target,
+		Threads:     2,
+		Checksums:   true,
+		FileTimeout: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Errors) != 1 || !strings.Contains(result.Errors[0].Error(), "Timed out") {
+		t.Fatalf("got %v, want the stuck file timed out", result.Errors)
+	}
+
+	// the abandoned copy goes on once its read returns, but must stop
+	// there and leave nothing behind
+	close(release)
+	time.Sleep(100 * time.Millisecond)
+	checkTree(t, target, map[string]string{"fine": "fine"})
+}
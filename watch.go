@@ -0,0 +1,259 @@
+package gocp
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// DefaultDebounce is how long Watch waits for a burst of changes to settle
+// when given no debounce.
+const DefaultDebounce = 500 * time.Millisecond
+
+// ErrWatchFlatten is returned by Watch when Flatten is set, since changes
+// can't be placed in a flattened target.
+var ErrWatchFlatten = errors.New("Watch cannot be used with Flatten.")
+
+// Watch keeps the target of opts in sync with its sources until ctx is done,
+// usually after a first Copy. Changed files are copied again once no change
+// arrived for debounce, and with Mirror, paths deleted from a source are
+// deleted from the target. New folders are watched as they appear. The
+// filters of opts apply, except for ignore files.
+//
+// Errors on individual paths are logged to opts.Logger and don't stop the
+// watch. An error is returned when the watch can't start or fails.
+func Watch(ctx context.Context, opts Options, debounce time.Duration) error {
+	if opts.Threads == 0 {
+		opts.Threads = DefaultThreads()
+	}
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = DefaultBufferSize
+	}
+	// a sync is too short to be worth a progress bar
+	opts.Progress = noProgress{}
+	if opts.Logger == nil {
+		opts.Logger = discardLogger
+	}
+	if err := opts.applyRootName(); err != nil {
+		return err
+	}
+	if debounce <= 0 {
+		debounce = DefaultDebounce
+	}
+	if opts.Flatten {
+		return ErrWatchFlatten
+	}
+
+	// the report only parses the filters; each sync gets its own
+	rep, err := newReport(&opts, func() {})
+	if err != nil {
+		return err
+	}
+	if opts.SourceFS != nil {
+		return ErrSourceFS
+	}
+	if opts.TargetFS != nil {
+		return ErrTargetFS
+	}
+	sources := opts.sources()
+	infos, err := statSources(osSource{}, sources)
+	if err != nil {
+		return err
+	}
+	if err := checkOverlap(sources, infos, opts.Target); err != nil {
+		return err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	w := &sourceWatch{opts: &opts, watcher: watcher}
+	for i, source := range sources {
+		if infos[i].IsDir() {
+			w.roots = append(w.roots, watchRoot{source, rep.rootOf(source), true, rep.targetBelow(source)})
+			if err := w.addTree(source, rep.targetBelow(source)); err != nil {
+				return err
+			}
+		} else {
+			w.roots = append(w.roots, watchRoot{source, filepath.Dir(source), false, ""})
+			if err := watcher.Add(filepath.Dir(source)); err != nil {
+				return err
+			}
+		}
+	}
+
+	pending := map[string]bool{}
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-opts.Drain:
+			// a sync under way has finished by now
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if event.Op == fsnotify.Chmod && !opts.Preserve {
+				continue
+			}
+			pending[event.Name] = true
+			timer.Reset(debounce)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				// changes were lost, so look at everything again
+				opts.Logger.Warn("Missed changes, syncing the whole source", "error", err)
+				for _, root := range w.roots {
+					pending[root.source] = true
+				}
+				timer.Reset(debounce)
+				continue
+			}
+			return err
+		case <-timer.C:
+			w.sync(ctx, pending)
+			pending = map[string]bool{}
+		}
+	}
+}
+
+// watchRoot is a source of a Watch. Paths below it are copied relative to
+// root, which is the source itself for a folder and its parent for a file.
+// target is where the target lies below a folder source, which is not
+// watched.
+type watchRoot struct {
+	source string
+	root   string
+	dir    bool
+	target string
+}
+
+// sourceWatch holds the state of a Watch.
+type sourceWatch struct {
+	opts    *Options
+	watcher *fsnotify.Watcher
+	roots   []watchRoot
+}
+
+// rootOf returns the root path is copied relative to, if it belongs to one
+// of the sources.
+func (w *sourceWatch) rootOf(path string) (string, bool) {
+	for _, root := range w.roots {
+		if root.target != "" && isWithin(root.target, path) {
+			return "", false
+		}
+		if (root.dir && isWithin(root.source, path)) || path == root.source {
+			return root.root, true
+		}
+	}
+	return "", false
+}
+
+// addTree watches dir and the folders below it, but for the target.
+func (w *sourceWatch) addTree(dir, target string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == target {
+			return filepath.SkipDir
+		}
+		if d.IsDir() {
+			return w.watcher.Add(path)
+		}
+		return nil
+	})
+}
+
+// sync brings the target up to date with the changed paths.
+func (w *sourceWatch) sync(ctx context.Context, paths map[string]bool) {
+	start := time.Now()
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	rep, _ := newReport(w.opts, cancel)
+
+	pool := NewThreadPool(int(w.opts.Threads))
+	for path := range paths {
+		w.syncPath(runCtx, path, pool, rep)
+	}
+	pool.Stop()
+
+	w.opts.Logger.Info("Synced changes", "paths", len(paths), "copied", rep.copied.Load(),
+		"deleted", rep.deleted.Load(), "errors", len(rep.errors), "elapsed", time.Since(start))
+}
+
+// syncPath copies path to the target, or deletes it from there if it is gone
+// and Mirror is set. A folder is copied with everything below it.
+func (w *sourceWatch) syncPath(ctx context.Context, path string, pool *ThreadPool, rep *report) {
+	root, ok := w.rootOf(path)
+	if !ok || ctx.Err() != nil {
+		return
+	}
+	relativePath, _ := filepath.Rel(root, path)
+	if relativePath != "." && rep.exclude.match(relativePath) {
+		return
+	}
+	destPath := w.opts.targetPath(relativePath)
+
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		if w.opts.Mirror && relativePath != "." {
+			if err := os.RemoveAll(destPath); err != nil {
+				rep.fail("deleting", destPath, err)
+			} else {
+				rep.deleted.Add(1)
+			}
+		}
+		return
+	}
+	if err != nil {
+		rep.fail("reading", path, err)
+		return
+	}
+	if w.opts.Follow && info.Mode()&os.ModeSymlink != 0 {
+		if info, err = os.Stat(path); err != nil {
+			rep.fail("following link", path, err)
+			return
+		}
+	}
+
+	switch {
+	case info.IsDir():
+		// the folders below are added as they are synced in turn
+		if err := w.watcher.Add(path); err != nil {
+			rep.fail("watching", path, err)
+		}
+		// as the first copy creates it, writable until the files inside
+		// are copied, which a watch never finishes with
+		createFolders(ctx, w.opts, []fileEntry{{path, info, root}}, rep)
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			rep.fail("reading directory", path, err)
+			return
+		}
+		for _, entry := range entries {
+			w.syncPath(ctx, filepath.Join(path, entry.Name()), pool, rep)
+		}
+	case isSpecial(info.Mode()) && (!w.opts.Special || info.Mode()&os.ModeSocket != 0):
+		rep.specials.Add(1)
+	case rep.selects(relativePath, info):
+		file := fileEntry{path, info, root}
+		pool.Submit(ctx, func() {
+			copyEntry(ctx, w.opts, file, destPath, rep)
+		}, func(p *PanicError) {
+			rep.fail("copying file", file.path, p)
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package gocp
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// A folder the watch finds gets the mode the first copy would give it.
+func TestWatchFolderMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("folder modes are not kept on Windows")
+	}
+	tests := []struct {
+		name string
+		opts Options
+		want os.FileMode
+	}{
+		{"preserve", Options{Preserve: true}, 0o750},
+		{"dir mode", Options{DirMode: 0o705}, 0o705},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			source := filepath.Join(dir, "source")
+			target := filepath.Join(dir, "target")
+			writeTree(t, source, map[string]string{"file": "file"})
+			opts := test.opts
+			opts.Source, opts.Target = source, target
+			copyTree(t, opts)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error, 1)
+			go func() { done <- Watch(ctx, opts, 10*time.Millisecond) }()
+			defer func() {
+				cancel()
+				<-done
+			}()
+			// give the watch time to start
+			time.Sleep(100 * time.Millisecond)
+			if err := os.Mkdir(filepath.Join(source, "new"), 0o750); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chmod(filepath.Join(source, "new"), 0o750); err != nil {
+				t.Fatal(err)
+			}
+
+			// the mode is set just after the folder is created
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				info, err := os.Stat(filepath.Join(target, "new"))
+				if err == nil && info.Mode().Perm() == test.want {
+					return
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("the new folder didn't get mode %v: %v", test.want, err)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	}
+}
@@ -0,0 +1,24 @@
+package gocp
+
+import (
+	"errors"
+	"log/slog"
+)
+
+// errXattrUnsupported is returned by copyXattrs where the platform or the
+// file system has no extended attributes.
+var errXattrUnsupported = errors.New("extended attributes are not supported")
+
+// preserveXattrs copies the extended attributes of src to dst. Attributes the
+// target file system doesn't support are reported once to log as a warning
+// instead of failing the copy.
+func (r *report) preserveXattrs(log *slog.Logger, src, dst string, link bool) error {
+	err := copyXattrs(src, dst, link)
+	if errors.Is(err, errXattrUnsupported) {
+		r.xattrWarning.Do(func() {
+			log.Warn("Cannot preserve extended attributes", "error", err)
+		})
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,8 @@
+//go:build !(linux || darwin)
+
+package gocp
+
+// copyXattrs is not implemented on this platform.
+func copyXattrs(src, dst string, link bool) error {
+	return errXattrUnsupported
+}
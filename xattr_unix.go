@@ -0,0 +1,67 @@
+//go:build linux || darwin
+
+package gocp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// copyXattrs sets every extended attribute of src on dst, acting on links
+// themselves rather than their targets when link is set.
+func copyXattrs(src, dst string, link bool) error {
+	list, get, set := unix.Listxattr, unix.Getxattr, unix.Setxattr
+	if link {
+		list, get, set = unix.Llistxattr, unix.Lgetxattr, unix.Lsetxattr
+	}
+
+	names, err := readXattr(func(buf []byte) (int, error) { return list(src, buf) })
+	if err != nil {
+		return xattrError(err)
+	}
+
+	for _, name := range bytes.Split(names, []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		value, err := readXattr(func(buf []byte) (int, error) { return get(src, string(name), buf) })
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", name, xattrError(err))
+		}
+		if err := set(dst, string(name), value, 0); err != nil {
+			return fmt.Errorf("setting %s: %w", name, xattrError(err))
+		}
+	}
+	return nil
+}
+
+// readXattr calls read with a buffer large enough for its result, which may
+// grow between asking for the size and reading it.
+func readXattr(read func([]byte) (int, error)) ([]byte, error) {
+	for {
+		size, err := read(nil)
+		if err != nil || size == 0 {
+			return nil, err
+		}
+		buf := make([]byte, size)
+		n, err := read(buf)
+		if errors.Is(err, unix.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+}
+
+// xattrError marks the errors of file systems without extended attributes.
+func xattrError(err error) error {
+	if errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EOPNOTSUPP) {
+		return fmt.Errorf("%w: %w", errXattrUnsupported, err)
+	}
+	return err
+}